@@ -0,0 +1,179 @@
+package main
+
+import (
+	"time"
+)
+
+type (
+	Achievements struct {
+		Streaks []Streak
+		Badges  []Badge
+	}
+
+	Streak struct {
+		Name    string
+		Unit    string // "days" or "weeks"
+		Current int
+		Longest int
+	}
+
+	Badge struct {
+		Name        string
+		Description string
+		EarnedOn    time.Time // zero if not yet earned
+	}
+)
+
+func (b Badge) Earned() bool {
+	return !b.EarnedOn.IsZero()
+}
+
+// streakRule is a run of consecutive periods (ending today) that each satisfy met
+type streakRule struct {
+	Name       string
+	Unit       string
+	PeriodDays int
+	Met        func(model ScoringModel, claims JWTClaims, period []DayLog) bool
+}
+
+// badgeStats is the running state of the history as of Date, which badge rules are evaluated against
+type badgeStats struct {
+	Date          time.Time
+	ActiveDays    int
+	LifetimeTotal time.Duration
+	Week          Summary // summary of the seven days ending on Date
+}
+
+// badgeRule is awarded the first day Earned returns true
+type badgeRule struct {
+	Name        string
+	Description string
+	Earned      func(s badgeStats) bool
+}
+
+var streakRules = []streakRule{
+	{
+		Name:       "Weekly Goal",
+		Unit:       "weeks",
+		PeriodDays: 7,
+		Met: func(model ScoringModel, claims JWTClaims, period []DayLog) bool {
+			return model.Summarize(claims, period).ComboScore >= 100
+		},
+	},
+	{
+		Name:       "Active Days",
+		Unit:       "days",
+		PeriodDays: 1,
+		Met: func(_ ScoringModel, _ JWTClaims, period []DayLog) bool {
+			return hasActivity(period)
+		},
+	},
+}
+
+var badgeRules = []badgeRule{
+	{
+		Name:        "First Step",
+		Description: "logged the first activity",
+		Earned:      func(s badgeStats) bool { return s.ActiveDays >= 1 },
+	},
+	{
+		Name:        "Goal Getter",
+		Description: "hit 100% of the weekly goal",
+		Earned:      func(s badgeStats) bool { return s.Week.ComboScore >= 100 },
+	},
+	{
+		Name:        "Overachiever",
+		Description: "hit the 200% bonus level in a week",
+		Earned:      func(s badgeStats) bool { return s.Week.BonusLevel > 0 && s.Week.ComboScore >= s.Week.BonusLevel },
+	},
+	{
+		Name:        "Ten Hours",
+		Description: "10 hours of lifetime activity",
+		Earned:      func(s badgeStats) bool { return s.LifetimeTotal >= 10*time.Hour },
+	},
+	{
+		Name:        "Century",
+		Description: "100 hours of lifetime activity",
+		Earned:      func(s badgeStats) bool { return s.LifetimeTotal >= 100*time.Hour },
+	},
+}
+
+// calcAchievements evaluates the streak and badge rules against the full history
+// days is expected to be descending and filled in up to today (see fillInDates)
+func calcAchievements(model ScoringModel, claims JWTClaims, days []DayLog) Achievements {
+	return evalAchievements(model, claims, days, streakRules, badgeRules)
+}
+
+func evalAchievements(model ScoringModel, claims JWTClaims, days []DayLog, sRules []streakRule, bRules []badgeRule) Achievements {
+	a := Achievements{}
+	for _, r := range sRules {
+		a.Streaks = append(a.Streaks, evalStreak(model, claims, days, r))
+	}
+	a.Badges = evalBadges(model, claims, days, bRules)
+	return a
+}
+
+func evalStreak(model ScoringModel, claims JWTClaims, days []DayLog, r streakRule) Streak {
+	s := Streak{Name: r.Name, Unit: r.Unit}
+
+	run := 0
+	current := true
+	for i := 0; i+r.PeriodDays <= len(days); i += r.PeriodDays {
+		if r.Met(model, claims, days[i:i+r.PeriodDays]) {
+			run++
+			s.Longest = max(s.Longest, run)
+			continue
+		}
+
+		// the most recent period is still in progress, so it doesn't break the current streak
+		if current && i > 0 {
+			s.Current = run
+			current = false
+		}
+		run = 0
+	}
+	if current {
+		s.Current = run
+	}
+	return s
+}
+
+func evalBadges(model ScoringModel, claims JWTClaims, days []DayLog, rules []badgeRule) []Badge {
+	badges := make([]Badge, len(rules))
+	for i, r := range rules {
+		badges[i] = Badge{Name: r.Name, Description: r.Description}
+	}
+
+	stats := badgeStats{}
+	// walk from the oldest day forward so the first day a rule is met is recorded
+	for i := len(days) - 1; i >= 0; i-- {
+		d := days[i]
+		stats.Date = d.Date
+		if hasActivity([]DayLog{d}) {
+			stats.ActiveDays++
+		}
+		for _, e := range d.Entries {
+			stats.LifetimeTotal += e.Duration
+		}
+		stats.Week = Summary{}
+		if i+7 <= len(days) {
+			stats.Week = model.Summarize(claims, days[i:i+7])
+		}
+
+		for j, r := range rules {
+			if !badges[j].Earned() && r.Earned(stats) {
+				badges[j].EarnedOn = d.Date
+			}
+		}
+	}
+	return badges
+}
+
+func hasActivity(days []DayLog) bool {
+	for _, d := range days {
+		if len(d.Entries) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_evalStreak(t *testing.T) {
+	active := []DayEntry{{Duration: time.Hour, Effort: 0.5}}
+
+	// days are descending from today, like fillInDates returns
+	daysFrom := func(activity ...bool) []DayLog {
+		var days []DayLog
+		for i, a := range activity {
+			d := DayLog{Date: time.Date(2024, 6, 17-i, 0, 0, 0, 0, time.UTC)}
+			if a {
+				d.Entries = active
+			}
+			days = append(days, d)
+		}
+		return days
+	}
+
+	activeDays := streakRule{
+		Name:       "Active",
+		Unit:       "days",
+		PeriodDays: 1,
+		Met: func(_ ScoringModel, _ JWTClaims, period []DayLog) bool {
+			return hasActivity(period)
+		},
+	}
+
+	tests := []struct {
+		name        string
+		days        []DayLog
+		wantCurrent int
+		wantLongest int
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:        "all-active",
+			days:        daysFrom(true, true, true),
+			wantCurrent: 3,
+			wantLongest: 3,
+		},
+		{
+			name:        "today-in-progress",
+			days:        daysFrom(false, true, true, false, true),
+			wantCurrent: 2,
+			wantLongest: 2,
+		},
+		{
+			name:        "broken-yesterday",
+			days:        daysFrom(true, false, true, true, true),
+			wantCurrent: 1,
+			wantLongest: 3,
+		},
+		{
+			name:        "nothing-recent",
+			days:        daysFrom(false, false, true),
+			wantCurrent: 0,
+			wantLongest: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evalStreak(effortScoringModel{}, JWTClaims{}, tt.days, activeDays)
+			if got.Current != tt.wantCurrent || got.Longest != tt.wantLongest {
+				t.Errorf("evalStreak() = %d/%d, want %d/%d", got.Current, got.Longest, tt.wantCurrent, tt.wantLongest)
+			}
+		})
+	}
+}
+
+func Test_evalBadges(t *testing.T) {
+	claims := JWTClaims{DateOfBirth: time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	// 14 days, oldest week has 7h moderate (280%), newest week nothing
+	var days []DayLog
+	for i := 0; i < 14; i++ {
+		d := DayLog{Date: time.Date(2024, 6, 17-i, 0, 0, 0, 0, time.UTC)}
+		if i >= 7 {
+			d.Entries = []DayEntry{{Duration: time.Hour, Effort: 0.6}}
+		}
+		days = append(days, d)
+	}
+
+	got := evalBadges(effortScoringModel{}, claims, days, badgeRules)
+
+	want := map[string]time.Time{
+		"First Step":   time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC),
+		"Goal Getter":  time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC), // the first full week
+		"Overachiever": time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC),
+		"Ten Hours":    {},
+		"Century":      {},
+	}
+	for _, b := range got {
+		if !b.EarnedOn.Equal(want[b.Name]) {
+			t.Errorf("badge %s earned on %v, want %v", b.Name, b.EarnedOn, want[b.Name])
+		}
+	}
+}
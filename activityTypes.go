@@ -0,0 +1,152 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const userActivityTypesFileName = "activity-types.json"
+
+// ActivityType is a kind of activity with its metabolic equivalent (MET) and a typical effort
+type ActivityType struct {
+	Name          string
+	MET           float64
+	DefaultEffort float32
+}
+
+// https://pacompendium.com/ rounded to something reasonable
+var defaultActivityTypes = []ActivityType{
+	{Name: "walk", MET: 3.5, DefaultEffort: 0.4},
+	{Name: "hike", MET: 6.0, DefaultEffort: 0.6},
+	{Name: "run", MET: 9.8, DefaultEffort: 0.8},
+	{Name: "cycling", MET: 7.5, DefaultEffort: 0.65},
+	{Name: "swimming", MET: 6.0, DefaultEffort: 0.7},
+	{Name: "strength training", MET: 5.0, DefaultEffort: 0.6},
+	{Name: "yoga", MET: 2.5, DefaultEffort: 0.3},
+	{Name: "yard work", MET: 4.0, DefaultEffort: 0.45},
+	{Name: "cleaning", MET: 3.3, DefaultEffort: 0.35},
+}
+
+// TypeSum is the total duration for a single activity type
+type TypeSum struct {
+	Type string
+	Sum  time.Duration
+}
+
+const otherActivityType = "other"
+
+// loadActivityTypes returns the default catalog plus any the user has added
+func loadActivityTypes(ctx context.Context, getFileHandler fileHandlerFunc, username string) ([]ActivityType, error) {
+	f, err := getFileHandler(ctx, username, userActivityTypesFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer safeClose(ctx, f, "activity types")
+
+	var userTypes []ActivityType
+	err = json.NewDecoder(f).Decode(&userTypes)
+	if err != nil && !isNotExist(err) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading activity types: %w", err)
+	}
+	return mergeActivityTypes(defaultActivityTypes, userTypes), nil
+}
+
+// addUserActivityType adds (or replaces) a user-defined activity type
+func addUserActivityType(ctx context.Context, getFileHandler fileHandlerFunc, username string, t ActivityType) error {
+	t.Name = normalizeActivityType(t.Name)
+	if t.Name == "" {
+		return errors.New("activity type needs a name")
+	}
+	if t.MET <= 0 {
+		return errors.New("activity type needs a positive MET value")
+	}
+	if t.DefaultEffort < 0 || t.DefaultEffort > 1 {
+		return errors.New("activity type default effort must be between 0 and 1")
+	}
+
+	r, err := getFileHandler(ctx, username, userActivityTypesFileName)
+	if err != nil {
+		return err
+	}
+	var userTypes []ActivityType
+	err = json.NewDecoder(r).Decode(&userTypes)
+	safeClose(ctx, r, "read activity types")
+	if err != nil && !isNotExist(err) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading activity types: %w", err)
+	}
+
+	userTypes = mergeActivityTypes(userTypes, []ActivityType{t})
+
+	w, err := getFileHandler(ctx, username, userActivityTypesFileName)
+	if err != nil {
+		return err
+	}
+	defer safeClose(ctx, w, "write activity types")
+	return json.NewEncoder(w).Encode(userTypes)
+}
+
+// mergeActivityTypes returns base with overrides replacing or appending by name
+func mergeActivityTypes(base, overrides []ActivityType) []ActivityType {
+	merged := slices.Clone(base)
+	for _, o := range overrides {
+		i := slices.IndexFunc(merged, func(t ActivityType) bool { return t.Name == o.Name })
+		if i >= 0 {
+			merged[i] = o
+		} else {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+func findActivityType(activityTypes []ActivityType, name string) (ActivityType, bool) {
+	name = normalizeActivityType(name)
+	i := slices.IndexFunc(activityTypes, func(t ActivityType) bool { return t.Name == name })
+	if i < 0 {
+		return ActivityType{}, false
+	}
+	return activityTypes[i], true
+}
+
+func normalizeActivityType(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// calcTypeSums totals durations per activity type, largest first
+func calcTypeSums(days []DayLog) []TypeSum {
+	var sums []TypeSum
+	for _, d := range days {
+		for _, e := range d.Entries {
+			t := e.Type
+			if t == "" {
+				t = otherActivityType
+			}
+			i := slices.IndexFunc(sums, func(s TypeSum) bool { return s.Type == t })
+			if i < 0 {
+				sums = append(sums, TypeSum{Type: t})
+				i = len(sums) - 1
+			}
+			sums[i].Sum += e.Duration
+		}
+	}
+	slices.SortStableFunc(sums, func(a, b TypeSum) int {
+		return cmp.Compare(b.Sum, a.Sum)
+	})
+	return sums
+}
+
+// isNotExist is true if the error is from a local or s3 file not existing yet
+func isNotExist(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	return errors.Is(err, fs.ErrNotExist) || errors.As(err, &noSuchKey)
+}
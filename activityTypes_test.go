@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_calcTypeSums(t *testing.T) {
+	days := []DayLog{
+		{Entries: []DayEntry{
+			{Duration: 30 * time.Minute, Type: "walk"},
+			{Duration: time.Hour, Type: "run"},
+		}},
+		{Entries: []DayEntry{
+			{Duration: 45 * time.Minute, Type: "walk"},
+			{Duration: 10 * time.Minute},
+		}},
+	}
+
+	want := []TypeSum{
+		{Type: "walk", Sum: 75 * time.Minute},
+		{Type: "run", Sum: time.Hour},
+		{Type: otherActivityType, Sum: 10 * time.Minute},
+	}
+	if got := calcTypeSums(days); !reflect.DeepEqual(got, want) {
+		t.Errorf("calcTypeSums() = %v, want %v", got, want)
+	}
+}
+
+func Test_mergeActivityTypes(t *testing.T) {
+	base := []ActivityType{
+		{Name: "walk", MET: 3.5, DefaultEffort: 0.4},
+		{Name: "run", MET: 9.8, DefaultEffort: 0.8},
+	}
+	overrides := []ActivityType{
+		{Name: "run", MET: 11, DefaultEffort: 0.9},
+		{Name: "pickleball", MET: 4.5, DefaultEffort: 0.5},
+	}
+
+	want := []ActivityType{
+		{Name: "walk", MET: 3.5, DefaultEffort: 0.4},
+		{Name: "run", MET: 11, DefaultEffort: 0.9},
+		{Name: "pickleball", MET: 4.5, DefaultEffort: 0.5},
+	}
+	if got := mergeActivityTypes(base, overrides); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeActivityTypes() = %v, want %v", got, want)
+	}
+	if base[1].MET != 9.8 {
+		t.Errorf("mergeActivityTypes() modified base")
+	}
+}
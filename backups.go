@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshot names sort by time and are safe as s3 keys and directory names
+const snapshotNameFormat = "20060102T150405Z"
+
+// undo snapshots are <time>-undo-<user>, only that user's files from before a restore
+// they aren't snapshots to restore everyone else from, or to count towards retention
+const undoSnapshotMarker = "-undo-"
+
+// backupFileNames are the files copied into each snapshot, for every user
+var backupFileNames = []string{userInfoFileName, userDataFileName}
+
+// backupRetention is how many of the newest daily, weekly and monthly snapshots are kept, the rest are pruned
+type backupRetention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+var defaultBackupRetention = backupRetention{Daily: 7, Weekly: 4, Monthly: 12}
+
+// backupStore holds the snapshots, each is a copy of every user's files with the same layout as the live storage
+type backupStore struct {
+	root Storage // each "user" of root is a snapshot
+}
+
+func newBackupStore(cfg storageConfig) backupStore {
+	if cfg.Local {
+		return backupStore{root: LocalStorage{Dir: cfg.LocalBackupDir}}
+	}
+	return backupStore{root: cfg.s3Storage(cfg.BackupPrefix)}
+}
+
+// Snapshots lists when each snapshot was taken, oldest first
+func (b backupStore) Snapshots(ctx context.Context) ([]time.Time, error) {
+	names, err := b.root.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing snapshots: %w", err)
+	}
+	var snapshots []time.Time
+	for _, n := range names {
+		t, err := time.Parse(snapshotNameFormat, n)
+		if err != nil {
+			// not a snapshot
+			continue
+		}
+		snapshots = append(snapshots, t)
+	}
+	slices.SortFunc(snapshots, time.Time.Compare)
+	return snapshots, nil
+}
+
+func (b backupStore) Snapshot(t time.Time) Storage {
+	return b.root.Sub(t.UTC().Format(snapshotNameFormat))
+}
+
+// UndoSnapshots lists when each of the user's undo snapshots was taken, oldest first
+func (b backupStore) UndoSnapshots(ctx context.Context, username string) ([]time.Time, error) {
+	names, err := b.root.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing snapshots: %w", err)
+	}
+	var snapshots []time.Time
+	for _, n := range names {
+		at, user, ok := strings.Cut(n, undoSnapshotMarker)
+		if !ok || user != username {
+			continue
+		}
+		t, err := time.Parse(snapshotNameFormat, at)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, t)
+	}
+	slices.SortFunc(snapshots, time.Time.Compare)
+	return snapshots, nil
+}
+
+func (b backupStore) UndoSnapshot(t time.Time, username string) Storage {
+	return b.root.Sub(t.UTC().Format(snapshotNameFormat) + undoSnapshotMarker + username)
+}
+
+func (b backupStore) Delete(ctx context.Context, t time.Time) error {
+	return b.root.DeleteUser(ctx, t.UTC().Format(snapshotNameFormat))
+}
+
+// backupAll snapshots every user's files at now, then prunes the snapshots retention doesn't keep
+func backupAll(ctx context.Context, storage Storage, backups backupStore, now time.Time, retention backupRetention) (int, []time.Time, error) {
+	users, err := storage.ListUsers(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	snapshot := backups.Snapshot(now)
+	for _, u := range users {
+		err = backupUser(ctx, storage, snapshot, u)
+		if err != nil {
+			return 0, nil, fmt.Errorf("backing up %s: %w", u, err)
+		}
+	}
+
+	snapshots, err := backups.Snapshots(ctx)
+	if err != nil {
+		return len(users), nil, err
+	}
+	pruned := snapshotsToPrune(snapshots, retention)
+	for _, t := range pruned {
+		err = backups.Delete(ctx, t)
+		if err != nil {
+			return len(users), nil, fmt.Errorf("pruning %s: %w", t.Format(snapshotNameFormat), err)
+		}
+	}
+	return len(users), pruned, nil
+}
+
+// backupUser copies the user's files into the snapshot, any that don't exist are skipped
+func backupUser(ctx context.Context, storage, snapshot Storage, username string) error {
+	fileNames, err := userBackupFileNames(ctx, storage, username)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		err := copyFile(ctx, storage, snapshot, username, fileName)
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// userBackupFileNames is backupFileNames and, for the segmented layout, everything under entries/
+func userBackupFileNames(ctx context.Context, storage Storage, username string) ([]string, error) {
+	entries, err := listFiles(ctx, storage.Open, username, entriesDir)
+	if err != nil {
+		return nil, err
+	}
+	fileNames := slices.Clone(backupFileNames)
+	for _, e := range entries {
+		fileNames = append(fileNames, entriesDir+"/"+e)
+	}
+	return fileNames, nil
+}
+
+func copyFile(ctx context.Context, from, to Storage, username, fileName string) error {
+	src, err := from.Open(ctx, username, fileName)
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(src)
+	safeClose(ctx, src, "copy from")
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", fileName, err)
+	}
+
+	dst, err := to.Open(ctx, username, fileName)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(b)
+	if err != nil {
+		safeClose(ctx, dst, "copy to")
+		return fmt.Errorf("writing %s: %w", fileName, err)
+	}
+	return dst.Close()
+}
+
+// snapshotsToPrune keeps the newest snapshot of each of the latest days, weeks and months that have one
+func snapshotsToPrune(snapshots []time.Time, retention backupRetention) []time.Time {
+	newestFirst := slices.Clone(snapshots)
+	slices.SortFunc(newestFirst, func(a, b time.Time) int { return b.Compare(a) })
+
+	keep := make(map[time.Time]bool)
+	keepNewest := func(n int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, s := range newestFirst {
+			p := period(s)
+			if seen[p] {
+				continue
+			}
+			if len(seen) == n {
+				return
+			}
+			seen[p] = true
+			keep[s] = true
+		}
+	}
+	keepNewest(retention.Daily, func(t time.Time) string { return t.Format(time.DateOnly) })
+	keepNewest(retention.Weekly, func(t time.Time) string { return startOfWeek(t).Format(time.DateOnly) })
+	keepNewest(retention.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	var prune []time.Time
+	for _, s := range snapshots {
+		if !keep[s] {
+			prune = append(prune, s)
+		}
+	}
+	return prune
+}
+
+// restorePlan is what restoring a user from a snapshot would change
+type restorePlan struct {
+	Username        string
+	Snapshot        time.Time
+	Undo            bool // the snapshot is one of the user's undo snapshots
+	UserInfoChanges []string
+	Removed         [][]string // data rows that aren't in the snapshot
+	Added           [][]string // data rows that are only in the snapshot
+}
+
+// planRestore finds the latest snapshot at or before at, or undo snapshot, and compares it to the user's files now
+func planRestore(ctx context.Context, storage Storage, backups backupStore, username string, at time.Time, undo bool) (restorePlan, error) {
+	snapshots, err := backups.Snapshots(ctx)
+	if undo {
+		snapshots, err = backups.UndoSnapshots(ctx, username)
+	}
+	if err != nil {
+		return restorePlan{}, err
+	}
+	i, found := slices.BinarySearchFunc(snapshots, at, time.Time.Compare)
+	if !found {
+		i--
+	}
+	if i < 0 {
+		return restorePlan{}, fmt.Errorf("no snapshot at or before %s", at.Format(time.RFC3339))
+	}
+	plan := restorePlan{Username: username, Snapshot: snapshots[i], Undo: undo}
+	snapshot := backups.planSnapshot(plan)
+
+	then, err := readUserInfo(ctx, snapshot.Open, username)
+	if isNotExist(err) {
+		return plan, fmt.Errorf("%s isn't in the snapshot from %s", username, plan.Snapshot.Format(time.RFC3339))
+	}
+	if err != nil {
+		return plan, err
+	}
+	now, err := readUserInfo(ctx, storage.Open, username)
+	if err != nil && !isNotExist(err) {
+		return plan, err
+	}
+	plan.UserInfoChanges = diffUserInfo(now, then)
+
+	// dry runs, so neither file is migrated
+	thenRecords, err := allDataRecords(ctx, snapshot.Open, username)
+	if err != nil && !isNotExist(err) {
+		return plan, err
+	}
+	nowRecords, err := allDataRecords(ctx, storage.Open, username)
+	if err != nil && !isNotExist(err) {
+		return plan, err
+	}
+	plan.Removed, plan.Added = diffRecords(nowRecords, thenRecords)
+	return plan, nil
+}
+
+func (b backupStore) planSnapshot(plan restorePlan) Storage {
+	if plan.Undo {
+		return b.UndoSnapshot(plan.Snapshot, plan.Username)
+	}
+	return b.Snapshot(plan.Snapshot)
+}
+
+// restoreUser snapshots the user's files as they are now into an undo snapshot, then copies the plan's snapshot back
+func restoreUser(ctx context.Context, storage Storage, backups backupStore, plan restorePlan, now time.Time) error {
+	undo := now.Truncate(time.Second)
+	if plan.Undo && !undo.After(plan.Snapshot) {
+		// never over the undo snapshot being restored, names are only to the second
+		undo = plan.Snapshot.Add(time.Second)
+	}
+	err := backupUser(ctx, storage, backups.UndoSnapshot(undo, plan.Username), plan.Username)
+	if err != nil {
+		return fmt.Errorf("backing up before restore: %w", err)
+	}
+	snapshot := backups.planSnapshot(plan)
+	fileNames, err := userBackupFileNames(ctx, snapshot, plan.Username)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		err = copyFile(ctx, snapshot, storage, plan.Username, fileName)
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+	}
+
+	// entries that weren't there then would be read along with the restored ones
+	entries, err := listFiles(ctx, storage.Open, plan.Username, entriesDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !slices.Contains(fileNames, entriesDir+"/"+e) {
+			err = removeUserFile(ctx, storage.Open, plan.Username, entriesDir+"/"+e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// diffUserInfo describes what changes going from a to b, without showing secrets
+func diffUserInfo(a, b UserInfo) []string {
+	var changes []string
+	field := func(name string, from, to any) {
+		if from != to {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, from, to))
+		}
+	}
+	field("resting heart rate", a.RestingHeartrate, b.RestingHeartrate)
+	field("date of birth", a.DateOfBirth.Format(time.DateOnly), b.DateOfBirth.Format(time.DateOnly))
+	field("scoring model", a.ScoringModel, b.ScoringModel)
+	field("admin", a.Admin, b.Admin)
+	if a.Password != b.Password {
+		changes = append(changes, "password changes")
+	}
+	if a.FeedToken != b.FeedToken {
+		changes = append(changes, "calendar feed url changes")
+	}
+	if a.Notifications != b.Notifications {
+		changes = append(changes, "email notification settings change")
+	}
+	return changes
+}
+
+// diffRecords returns the rows only in a and the rows only in b, counting duplicates
+func diffRecords(a, b [][]string) ([][]string, [][]string) {
+	counts := make(map[string]int)
+	for _, r := range b {
+		counts[strings.Join(r, ",")]++
+	}
+	var onlyA [][]string
+	for _, r := range a {
+		key := strings.Join(r, ",")
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		onlyA = append(onlyA, r)
+	}
+	var onlyB [][]string
+	for _, r := range b {
+		key := strings.Join(r, ",")
+		if counts[key] > 0 {
+			counts[key]--
+			onlyB = append(onlyB, r)
+		}
+	}
+	return onlyA, onlyB
+}
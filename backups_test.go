@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_snapshotsToPrune(t *testing.T) {
+	at := func(date string, hour int) time.Time {
+		d, _ := time.Parse(time.DateOnly, date)
+		return d.Add(time.Duration(hour) * time.Hour)
+	}
+	snapshots := []time.Time{
+		at("2024-04-30", 3), // last of april
+		at("2024-05-10", 3),
+		at("2024-05-31", 3), // last of may
+		at("2024-06-03", 3), // monday
+		at("2024-06-09", 3), // last of that week
+		at("2024-06-16", 3),
+		at("2024-06-17", 3),
+		at("2024-06-17", 4), // newest of the day
+	}
+	got := snapshotsToPrune(snapshots, backupRetention{Daily: 2, Weekly: 3, Monthly: 2})
+	want := []time.Time{
+		at("2024-04-30", 3),
+		at("2024-05-10", 3),
+		at("2024-06-03", 3),
+		at("2024-06-17", 3),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshotsToPrune() = %v, want %v", got, want)
+	}
+}
+
+func Test_backupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	backups := backupStore{root: LocalStorage{Dir: t.TempDir()}}
+	monday := time.Date(2024, 6, 17, 3, 0, 0, 0, time.UTC)
+
+	userInfo := UserInfo{Username: "alice", RestingHeartrate: 62}
+	err := createUser(ctx, storage.Open, userInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = addEntry(ctx, storage.Open, "alice", time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC), DayEntry{Duration: time.Hour, Effort: 0.5, Description: "walk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, pruned, err := backupAll(ctx, storage, backups, monday, defaultBackupRetention)
+	if err != nil || users != 1 || len(pruned) != 0 {
+		t.Fatalf("backupAll() = %d, %v, %v", users, pruned, err)
+	}
+
+	// the mistake to undo
+	_, err = deleteEntry(ctx, storage.Open, "alice", time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userInfo.RestingHeartrate = 70
+	err = writeUserInfo(ctx, storage.Open, userInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = planRestore(ctx, storage, backups, "alice", monday.Add(-time.Second), false)
+	if err == nil {
+		t.Errorf("planRestore() before any snapshot should fail")
+	}
+
+	plan, err := planRestore(ctx, storage, backups, "alice", monday.Add(time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Snapshot.Equal(monday) {
+		t.Errorf("plan snapshot = %v, want %v", plan.Snapshot, monday)
+	}
+	if !reflect.DeepEqual(plan.UserInfoChanges, []string{"resting heart rate: 70 -> 62"}) {
+		t.Errorf("plan user info changes = %v", plan.UserInfoChanges)
+	}
+	if len(plan.Removed) != 0 || !reflect.DeepEqual(plan.Added, [][]string{{"2024-06-16", "1h0m0s", "0.50", "walk", "", ""}}) {
+		t.Errorf("plan rows = -%v +%v", plan.Removed, plan.Added)
+	}
+
+	err = restoreUser(ctx, storage, backups, plan, monday.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil || len(days) != 1 {
+		t.Errorf("restored days = %v, %v", days, err)
+	}
+	restored, err := readUserInfo(ctx, storage.Open, "alice")
+	if err != nil || restored.RestingHeartrate != 62 {
+		t.Errorf("restored user info = %+v, %v", restored, err)
+	}
+
+	// the restore can be undone, from a snapshot that isn't one of the dated ones
+	snapshots, err := backups.Snapshots(ctx)
+	if err != nil || len(snapshots) != 1 {
+		t.Errorf("snapshots after restore = %v, %v", snapshots, err)
+	}
+	plan, err = planRestore(ctx, storage, backups, "alice", monday.Add(3*time.Hour), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Snapshot.Equal(monday.Add(2*time.Hour)) || len(plan.Removed) != 1 || len(plan.Added) != 0 {
+		t.Errorf("undo plan = %+v", plan)
+	}
+	err = restoreUser(ctx, storage, backups, plan, monday.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err = readUserInfo(ctx, storage.Open, "alice")
+	if err != nil || restored.RestingHeartrate != 70 {
+		t.Errorf("undone user info = %+v, %v", restored, err)
+	}
+}
+
+func Test_restoreUser_undoSnapshotIsNotASnapshot(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	backups := backupStore{root: LocalStorage{Dir: t.TempDir()}}
+	monday := time.Date(2024, 6, 17, 3, 0, 0, 0, time.UTC)
+
+	for _, username := range []string{"alice", "bob"} {
+		err := createUser(ctx, storage.Open, UserInfo{Username: username})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = addEntry(ctx, storage.Open, username, time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC), DayEntry{Duration: time.Hour, Effort: 0.5, Description: "walk"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, err := backupAll(ctx, storage, backups, monday, defaultBackupRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// restoring alice later that day saves only alice's files
+	plan, err := planRestore(ctx, storage, backups, "alice", monday, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restoreUser(ctx, storage, backups, plan, monday.Add(7*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// so the next day's backup mustn't keep it as monday's newest
+	_, pruned, err := backupAll(ctx, storage, backups, monday.AddDate(0, 0, 1), defaultBackupRetention)
+	if err != nil || len(pruned) != 0 {
+		t.Fatalf("backupAll() pruned %v, %v", pruned, err)
+	}
+
+	// and bob's restore to the end of monday mustn't land on it
+	plan, err = planRestore(ctx, storage, backups, "bob", monday.Add(20*time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Snapshot.Equal(monday) {
+		t.Errorf("plan snapshot = %v, want %v", plan.Snapshot, monday)
+	}
+}
@@ -0,0 +1,971 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"gopkg.in/yaml.v3"
+)
+
+const commandUsage = `commands:
+  serve [flags]                            run the web server, on :8080 by default
+  lambda                                   run as an aws lambda (the default with no command)
+  user add [flags]                         create a user, prompting for anything not given
+  user list                                list all users
+  user delete [--yes] <user>               delete a user and all their data
+  user reset-password [--password] <user>  set a new password
+  entry add --user <user> [flags]          add an entry
+  entry list --user <user> [--from] [--to] list entries, with the index used by delete
+  entry delete --user <user> --date <date> --index <n>
+  summary --user <user> [--date]           show the seven days ending on date
+  import --user <user> [--format] <file>   add entries from a csv or json export
+  export --user <user> [flags]             export as csv, json or ics
+  tui --user <user>                        interactive dashboard of the last two weeks
+  fsck [--user] [--repair] [--yes]         check data files, repairing or quarantining bad rows
+  migrate [--user] [--dry-run] [--layout]  upgrade data files to the current format, keeping backups
+  compact [--user]                         merge segmented users' appended entries into monthly segments
+  backup [--list]                          snapshot every user's files and prune old snapshots
+  restore --user <user> --to <time>        roll a user back to the latest snapshot at or before time
+  notify [--user] <reminders|digest>       email everyone who opted in, over SMTP_ADDR
+  config print                             show the config after the file, environment and flags, without secrets
+`
+
+// cli is what the commands run against
+type cli struct {
+	config  Config
+	storage Storage
+	backups backupStore
+	in      io.Reader
+	out     io.Writer
+}
+
+func (c *cli) getFileHandler() fileHandlerFunc {
+	return c.storage.Open
+}
+
+func runCommand(ctx context.Context, c *cli, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given\n%s", commandUsage)
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "serve":
+		return c.serve(ctx, args)
+	case "lambda":
+		return c.lambda(args)
+	case "user", "entry", "config":
+		if len(args) == 0 {
+			return fmt.Errorf("%s needs a subcommand\n%s", cmd, commandUsage)
+		}
+		cmd, args = cmd+" "+args[0], args[1:]
+	}
+
+	switch cmd {
+	case "user add":
+		return c.userAdd(ctx, args)
+	case "user list":
+		return c.userList(ctx, args)
+	case "user delete":
+		return c.userDelete(ctx, args)
+	case "user reset-password":
+		return c.userResetPassword(ctx, args)
+	case "entry add":
+		return c.entryAdd(ctx, args)
+	case "entry list":
+		return c.entryList(ctx, args)
+	case "entry delete":
+		return c.entryDelete(ctx, args)
+	case "summary":
+		return c.summary(ctx, args)
+	case "import":
+		return c.importEntries(ctx, args)
+	case "export":
+		return c.export(ctx, args)
+	case "tui":
+		return c.tui(ctx, args)
+	case "fsck":
+		return c.fsck(ctx, args)
+	case "migrate":
+		return c.migrate(ctx, args)
+	case "compact":
+		return c.compact(ctx, args)
+	case "backup":
+		return c.backup(ctx, args)
+	case "restore":
+		return c.restore(ctx, args)
+	case "notify":
+		return c.notify(ctx, args)
+	case "config print":
+		return c.configPrint(args)
+	case "help", "-h", "--help":
+		_, _ = fmt.Fprint(c.out, commandUsage)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q\n%s", cmd, commandUsage)
+}
+
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	return fs
+}
+
+// requireUser checks the --user flag was given and the user exists
+func (c *cli) requireUser(ctx context.Context, username string) error {
+	if username == "" {
+		return errors.New("--user is required")
+	}
+	exists, err := userExists(ctx, c.getFileHandler(), username)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("user %s does not exist", username)
+	}
+	return nil
+}
+
+// usersOrAll is the user, if one was given, or everyone
+func (c *cli) usersOrAll(ctx context.Context, username string) ([]string, error) {
+	if username == "" {
+		return c.storage.ListUsers(ctx)
+	}
+	if err := c.requireUser(ctx, username); err != nil {
+		return nil, err
+	}
+	return []string{username}, nil
+}
+
+func (c *cli) serve(ctx context.Context, args []string) error {
+	fs := c.flagSet("serve")
+	opts := c.config.Serve
+	opts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if err := c.config.requireJWTSecret(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	e := newServer(c.storage, c.config)
+	if c.config.MetricsToken != "" {
+		addMetricsRoute(e, newMetricsRegistry(c.storage), c.config.MetricsToken)
+	} else {
+		slog.Info("metrics_token (METRICS_TOKEN) is not set, there is no /metrics")
+	}
+
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+
+	// the lambda has scheduled jobs for these, a run in progress is finished before exiting
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		compactTicker := time.NewTicker(time.Hour)
+		defer compactTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				jobCtx := withLogAttrs(context.WithoutCancel(ctx), "job", "webhooks")
+				err := runWebhooksJob(jobCtx, c.storage, now)
+				if err != nil {
+					loggerFrom(jobCtx).Warn("webhooks job failed", "err", err)
+				}
+			case <-compactTicker.C:
+				jobCtx := withLogAttrs(context.WithoutCancel(ctx), "job", "compact")
+				_, err := compactAll(jobCtx, c.storage)
+				if err != nil {
+					loggerFrom(jobCtx).Warn("compact job failed", "err", err)
+				}
+			}
+		}
+	}()
+
+	slog.Info("Starting local execution", "addr", ln.Addr().String(), "tls", opts.SelfSigned || opts.TLSCert != "")
+	err = runServer(ctx, ln, e, opts)
+	stop()
+	<-jobsDone
+	return err
+}
+
+func (c *cli) lambda(args []string) error {
+	fs := c.flagSet("lambda")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := c.config.requireJWTSecret(); err != nil {
+		return err
+	}
+
+	slog.Info("Starting lambda execution")
+	dispatch := LambdaDispatcher(newServer(c.storage, c.config), c.lambdaJobs())
+	lambda.Start(func(ctx context.Context, payload json.RawMessage) (any, error) {
+		defer flushTraces(ctx)
+		return dispatch(ctx, payload)
+	})
+	return nil
+}
+
+// lambdaJobs are run by EventBridge schedules, see runScheduledJob
+func (c *cli) lambdaJobs() map[string]lambdaJob {
+	return map[string]lambdaJob{
+		"backup": func(ctx context.Context) error {
+			_, _, err := backupAll(ctx, c.storage, c.backups, time.Now(), defaultBackupRetention)
+			return err
+		},
+		// run daily, only sent on the day each user chose
+		"reminders": func(ctx context.Context) error {
+			_, err := sendNotifications(ctx, c.storage, c.config.SMTP, notifyReminder, "", time.Now())
+			return err
+		},
+		// run on Sundays
+		"digest": func(ctx context.Context) error {
+			_, err := sendNotifications(ctx, c.storage, c.config.SMTP, notifyDigest, "", time.Now())
+			return err
+		},
+		// run every few minutes, retries failed deliveries and sends goal.missed on Mondays
+		"webhooks": func(ctx context.Context) error {
+			return runWebhooksJob(ctx, c.storage, time.Now())
+		},
+		// run daily or so, keeps reads of segmented users to a file or two a month
+		"compact": func(ctx context.Context) error {
+			_, err := compactAll(ctx, c.storage)
+			return err
+		},
+	}
+}
+
+func (c *cli) userAdd(ctx context.Context, args []string) error {
+	var in userInput
+	fs := c.flagSet("user add")
+	fs.StringVar(&in.Username, "username", "", "username")
+	fs.StringVar(&in.Password, "password", "", "password, prefer --password-stdin")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin")
+	fs.StringVar(&in.RestingHeartrate, "resting-heart-rate", "", "resting heart rate in BPM")
+	fs.StringVar(&in.DateOfBirth, "dob", "", "date of birth "+time.DateOnly)
+	noInput := fs.Bool("no-input", false, "fail instead of prompting for missing fields")
+	admin := fs.Bool("admin", false, "allow the user to use the /admin endpoints")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := c.in
+	if *noInput {
+		r = strings.NewReader("")
+	}
+	if *passwordStdin {
+		var err error
+		in.Password, err = readLine(c.in)
+		if err != nil {
+			return fmt.Errorf("reading password: %w", err)
+		}
+	}
+
+	userInfo, err := userInfoFromIO(r, c.out, in)
+	if err != nil {
+		return err
+	}
+	userInfo.Admin = *admin
+	err = createUser(ctx, c.getFileHandler(), userInfo)
+	if err != nil {
+		return err
+	}
+	if layout := dataLayout(c.config.Storage.Layout); layout != layoutFile {
+		_, err = setLayout(ctx, c.getFileHandler(), userInfo.Username, layout)
+		if err != nil {
+			return err
+		}
+	}
+	_, _ = fmt.Fprintf(c.out, "created user %s\n", userInfo.Username)
+	return nil
+}
+
+func (c *cli) userList(ctx context.Context, args []string) error {
+	fs := c.flagSet("user list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	users, err := c.storage.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		_, _ = fmt.Fprintln(c.out, u)
+	}
+	return nil
+}
+
+func (c *cli) userDelete(ctx context.Context, args []string) error {
+	fs := c.flagSet("user delete")
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("user delete needs exactly one user")
+	}
+	username := fs.Arg(0)
+	if err := c.requireUser(ctx, username); err != nil {
+		return err
+	}
+
+	if !*yes {
+		_, _ = fmt.Fprintf(c.out, "delete %s and all their data? type the username to confirm: ", username)
+		line, _ := readLine(c.in)
+		if line != username {
+			return errors.New("not confirmed")
+		}
+	}
+
+	err := c.storage.DeleteUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.out, "deleted user %s\n", username)
+	return nil
+}
+
+func (c *cli) userResetPassword(ctx context.Context, args []string) error {
+	fs := c.flagSet("user reset-password")
+	password := fs.String("password", "", "new password, prompted for if not given")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("user reset-password needs exactly one user")
+	}
+
+	userInfo, err := readUserInfo(ctx, c.getFileHandler(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *password == "" {
+		_, _ = fmt.Fprint(c.out, "new password: ")
+		*password, err = readLine(c.in)
+		if err != nil {
+			return fmt.Errorf("reading password: %w", err)
+		}
+	}
+	userInfo.Password, err = hashPassword(*password)
+	if err != nil {
+		return fmt.Errorf("invalid password: %w", err)
+	}
+
+	err = writeUserInfo(ctx, c.getFileHandler(), userInfo)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.out, "reset password for %s\n", userInfo.Username)
+	return nil
+}
+
+func (c *cli) entryAdd(ctx context.Context, args []string) error {
+	form := newEntryForm(time.Now().Format(time.DateOnly))
+	effort := -1.0
+
+	fs := c.flagSet("entry add")
+	username := fs.String("user", "", "user to add the entry for")
+	fs.StringVar(&form.Date, "date", form.Date, "date of the entry "+time.DateOnly)
+	fs.StringVar(&form.Duration, "duration", "", "how long e.g. 30m, 1:15 or 9:00-9:45")
+	fs.Float64Var(&effort, "effort", effort, "effort from 0 to 1, defaults to the type's default effort")
+	fs.StringVar(&form.Type, "type", "", "activity type e.g. walk")
+	fs.StringVar(&form.Description, "description", "", "description")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.requireUser(ctx, *username); err != nil {
+		return err
+	}
+
+	if effort >= 0 {
+		form.Effort = float32(effort)
+	} else {
+		activityTypes, err := loadActivityTypes(ctx, c.getFileHandler(), *username)
+		if err != nil {
+			return err
+		}
+		if t, ok := findActivityType(activityTypes, form.Type); ok {
+			form.Effort = t.DefaultEffort
+		}
+	}
+
+	date, entry, ok := form.parse()
+	if !ok {
+		return formErrors(form)
+	}
+
+	err := addEntry(ctx, c.getFileHandler(), *username, date, entry)
+	if err != nil {
+		return err
+	}
+	sendEntryWebhooks(ctx, c.getFileHandler(), *username, webhookEntryCreated, date, entry, nil, time.Now())
+	_, _ = fmt.Fprintf(c.out, "added %s %s on %s\n", sumStr(entry.Duration), entry.Label(), date.Format(time.DateOnly))
+	return nil
+}
+
+func (c *cli) entryList(ctx context.Context, args []string) error {
+	fs := c.flagSet("entry list")
+	username := fs.String("user", "", "user to list entries for")
+	from := fs.String("from", "", "only entries on or after this date")
+	to := fs.String("to", "", "only entries on or before this date")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.requireUser(ctx, *username); err != nil {
+		return err
+	}
+	opts, err := parseExportOptions("", *from, *to)
+	if err != nil {
+		return err
+	}
+
+	days, err := readDaysBetween(ctx, c.getFileHandler(), *username, opts.From, opts.To)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "DATE\tINDEX\tDURATION\tEFFORT\tTYPE\tDESCRIPTION")
+	for _, d := range filterDays(days, opts.From, opts.To) {
+		for i, e := range d.Entries {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\t%.0f%%\t%s\t%s\n",
+				d.Date.Format(time.DateOnly), i, sumStr(e.Duration), 100*e.Effort, e.Type, e.Description)
+		}
+	}
+	return tw.Flush()
+}
+
+func (c *cli) entryDelete(ctx context.Context, args []string) error {
+	fs := c.flagSet("entry delete")
+	username := fs.String("user", "", "user to delete the entry from")
+	dateStr := fs.String("date", "", "date of the entry "+time.DateOnly)
+	index := fs.Int("index", -1, "index of the entry within the day, see entry list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.requireUser(ctx, *username); err != nil {
+		return err
+	}
+	date, err := time.Parse(time.DateOnly, *dateStr)
+	if err != nil {
+		return fmt.Errorf("--date must look like %s", time.DateOnly)
+	}
+
+	removed, err := deleteEntry(ctx, c.getFileHandler(), *username, date, *index)
+	if err != nil {
+		return err
+	}
+	sendEntryWebhooks(ctx, c.getFileHandler(), *username, webhookEntryDeleted, date, removed, nil, time.Now())
+	_, _ = fmt.Fprintf(c.out, "deleted %s %s on %s\n", sumStr(removed.Duration), removed.Label(), *dateStr)
+	return nil
+}
+
+func (c *cli) summary(ctx context.Context, args []string) error {
+	fs := c.flagSet("summary")
+	username := fs.String("user", "", "user to summarize")
+	dateStr := fs.String("date", time.Now().Format(time.DateOnly), "last day of the week "+time.DateOnly)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.requireUser(ctx, *username); err != nil {
+		return err
+	}
+	date, err := time.Parse(time.DateOnly, *dateStr)
+	if err != nil {
+		return fmt.Errorf("--date must look like %s", time.DateOnly)
+	}
+
+	userInfo, err := readUserInfo(ctx, c.getFileHandler(), *username)
+	if err != nil {
+		return err
+	}
+	v, err := loadTrackerView(ctx, c.getFileHandler(), newJWTClaims(userInfo, date), date)
+	if err != nil {
+		return err
+	}
+
+	s := v.Summary
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "week ending\t%s (%s model)\n", date.Format(time.DateOnly), s.Model)
+	_, _ = fmt.Fprintf(tw, "score\t%s of goal\n", scoreStr(s.ComboScore))
+	_, _ = fmt.Fprintf(tw, "remaining\t%s moderate\n", sumStr(s.RemainingModerateTime))
+	if s.Model == metMinutesScoringModelName {
+		_, _ = fmt.Fprintf(tw, "MET-minutes\t%s of %s\n", metMinutesStr(s.METMinutes), metMinutesStr(s.METMinutesGoal))
+	}
+	_, _ = fmt.Fprintf(tw, "low\t%s / %s\n", scoreStr(s.LowIntensityScore), sumStr(s.LowIntensitySum))
+	_, _ = fmt.Fprintf(tw, "moderate\t%s / %s\n", scoreStr(s.ModerateIntensityScore), sumStr(s.ModerateIntensitySum))
+	_, _ = fmt.Fprintf(tw, "high\t%s / %s\n", scoreStr(s.HighIntensityScore), sumStr(s.HighIntensitySum))
+	for _, t := range s.TypeSums {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\n", t.Type, sumStr(t.Sum))
+	}
+	for _, st := range v.Achievements.Streaks {
+		_, _ = fmt.Fprintf(tw, "%s streak\t%s (longest %d)\n", st.Name, streakStr(st), st.Longest)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) importEntries(ctx context.Context, args []string) error {
+	fs := c.flagSet("import")
+	username := fs.String("user", "", "user to import entries for")
+	format := fs.String("format", "", "csv or json, defaults to the file extension")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("import needs exactly one file, use - for stdin")
+	}
+	if err := c.requireUser(ctx, *username); err != nil {
+		return err
+	}
+
+	fileName := fs.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(fileName), ".")
+	}
+
+	var r io.ReadCloser = io.NopCloser(c.in)
+	if fileName != "-" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		r = f
+	}
+	defer safeClose(ctx, r, "import file")
+
+	var days []DayLog
+	var err error
+	switch *format {
+	case exportFormatCSV:
+		days, err = readCSV(ctx, r)
+	case exportFormatJSON:
+		var doc exportDocument
+		err = json.NewDecoder(r).Decode(&doc)
+		days = doc.Days
+	default:
+		return fmt.Errorf("can't import format %q", *format)
+	}
+	if err != nil {
+		return fmt.Errorf("reading import: %w", err)
+	}
+
+	added, skipped, err := importDays(ctx, c.getFileHandler(), *username, days)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.out, "imported %d entries, skipped %d already there\n", added, skipped)
+	return nil
+}
+
+func (c *cli) export(ctx context.Context, args []string) error {
+	fs := c.flagSet("export")
+	username := fs.String("user", "", "user to export")
+	format := fs.String("format", exportFormatCSV, "csv, json or ics")
+	from := fs.String("from", "", "only entries on or after this date")
+	to := fs.String("to", "", "only entries on or before this date")
+	out := fs.String("out", "", "file to write to instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.requireUser(ctx, *username); err != nil {
+		return err
+	}
+	opts, err := parseExportOptions(*format, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return exportUserData(ctx, c.getFileHandler(), *username, opts, c.out)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = exportUserData(ctx, c.getFileHandler(), *username, opts, f)
+	if err != nil {
+		safeClose(ctx, f, "export file")
+		return err
+	}
+	return f.Close()
+}
+
+func (c *cli) tui(ctx context.Context, args []string) error {
+	fs := c.flagSet("tui")
+	username := fs.String("user", "", "user to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.requireUser(ctx, *username); err != nil {
+		return err
+	}
+
+	userInfo, err := readUserInfo(ctx, c.getFileHandler(), *username)
+	if err != nil {
+		return err
+	}
+	return newTUI(ctx, c.getFileHandler(), newJWTClaims(userInfo, time.Now()), c.in, c.out).Run()
+}
+
+func (c *cli) fsck(ctx context.Context, args []string) error {
+	fs := c.flagSet("fsck")
+	username := fs.String("user", "", "only check this user")
+	repair := fs.Bool("repair", false, "repair, asking about each problem")
+	yes := fs.Bool("yes", false, "with --repair, fix what can be fixed and quarantine the rest without asking")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var decide func(string, fsckIssue) fsckAction
+	if *repair && *yes {
+		decide = func(_ string, issue fsckIssue) fsckAction { return autoRepair(issue) }
+	} else if *repair {
+		decide = c.askRepair
+	}
+
+	var reports []fsckReport
+	if *username != "" {
+		if err := c.requireUser(ctx, *username); err != nil {
+			return err
+		}
+		var userDecide func(fsckIssue) fsckAction
+		if decide != nil {
+			userDecide = func(issue fsckIssue) fsckAction { return decide(*username, issue) }
+		}
+		r, err := fsckUser(ctx, c.getFileHandler(), *username, time.Now(), userDecide)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, r)
+	} else {
+		var err error
+		reports, err = fsckAllUsers(ctx, c.storage, time.Now(), decide)
+		if err != nil {
+			return err
+		}
+	}
+
+	problems := 0
+	for _, r := range reports {
+		found := 0
+		for _, issue := range r.Issues {
+			if !issue.Warning() {
+				found++
+			}
+		}
+		_, _ = fmt.Fprintf(c.out, "%s: %d rows, %d problems", r.Username, r.Rows, found)
+		if *repair {
+			_, _ = fmt.Fprintf(c.out, ", %d fixed, %d quarantined", r.Fixed, r.Quarantined)
+		} else {
+			for _, issue := range r.Issues {
+				_, _ = fmt.Fprintf(c.out, "\n  row %d: %s", issue.Row, strings.Join(slices.Concat(issue.Problems, issue.Warnings), ", "))
+			}
+		}
+		_, _ = fmt.Fprintln(c.out)
+		problems += found - r.Fixed - r.Quarantined
+	}
+	if problems > 0 && !*repair {
+		return fmt.Errorf("found %d problems, --repair to fix them", problems)
+	}
+	return nil
+}
+
+func (c *cli) migrate(ctx context.Context, args []string) error {
+	fs := c.flagSet("migrate")
+	username := fs.String("user", "", "only migrate this user")
+	dryRun := fs.Bool("dry-run", false, "only show which versions the files are")
+	layout := fs.String("layout", "", "also move entries into the file or segmented layout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch dataLayout(*layout) {
+	case "", layoutFile, layoutSegmented:
+	default:
+		return fmt.Errorf("unknown layout %q, expected file or segmented", *layout)
+	}
+
+	users, err := c.usersOrAll(ctx, *username)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if *layout != "" {
+			err = c.migrateLayout(ctx, u, dataLayout(*layout), *dryRun)
+			if err != nil {
+				return fmt.Errorf("migrating %s: %w", u, err)
+			}
+		}
+		version, _, err := migrateUserData(ctx, c.getFileHandler(), u, *dryRun)
+		if errors.Is(err, errSegmentedLayout) {
+			_, _ = fmt.Fprintf(c.out, "%s: segmented, up to date\n", u)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("migrating %s: %w", u, err)
+		}
+		switch {
+		case version == dataFormatVersion:
+			_, _ = fmt.Fprintf(c.out, "%s: v%d, up to date\n", u, version)
+		case *dryRun:
+			_, _ = fmt.Fprintf(c.out, "%s: v%d, would migrate to v%d\n", u, version, dataFormatVersion)
+		default:
+			_, _ = fmt.Fprintf(c.out, "%s: migrated v%d to v%d, original kept in %s\n", u, version, dataFormatVersion, migrationBackupFileName(version))
+		}
+	}
+	return nil
+}
+
+func (c *cli) migrateLayout(ctx context.Context, username string, layout dataLayout, dryRun bool) error {
+	if dryRun {
+		current, err := userLayout(ctx, c.getFileHandler(), username)
+		if err == nil && current != layout {
+			_, _ = fmt.Fprintf(c.out, "%s: %s layout, would move to %s\n", username, current, layout)
+		}
+		return err
+	}
+	moved, err := setLayout(ctx, c.getFileHandler(), username, layout)
+	if moved {
+		_, _ = fmt.Fprintf(c.out, "%s: moved to the %s layout\n", username, layout)
+	}
+	return err
+}
+
+func (c *cli) compact(ctx context.Context, args []string) error {
+	fs := c.flagSet("compact")
+	username := fs.String("user", "", "only compact this user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	users, err := c.usersOrAll(ctx, *username)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		months, err := compactUser(ctx, c.getFileHandler(), u)
+		if err != nil {
+			return fmt.Errorf("compacting %s: %w", u, err)
+		}
+		if months > 0 {
+			_, _ = fmt.Fprintf(c.out, "%s: compacted %d months\n", u, months)
+		}
+	}
+	return nil
+}
+
+func (c *cli) backup(ctx context.Context, args []string) error {
+	fs := c.flagSet("backup")
+	list := fs.Bool("list", false, "list the snapshots instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *list {
+		snapshots, err := c.backups.Snapshots(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			_, _ = fmt.Fprintln(c.out, s.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	now := time.Now()
+	users, pruned, err := backupAll(ctx, c.storage, c.backups, now, defaultBackupRetention)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.out, "snapshot %s of %d users, pruned %d old snapshots\n", now.UTC().Format(time.RFC3339), users, len(pruned))
+	return nil
+}
+
+func (c *cli) restore(ctx context.Context, args []string) error {
+	fs := c.flagSet("restore")
+	username := fs.String("user", "", "user to restore")
+	to := fs.String("to", "", "RFC3339 time, or a date for the end of that day")
+	undo := fs.Bool("undo", false, "restore from the files saved by an earlier restore instead, the latest unless --to")
+	dryRun := fs.Bool("dry-run", false, "only show what would change")
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("--user is required")
+	}
+	at, err := time.Parse(time.RFC3339, *to)
+	if *undo && *to == "" {
+		at, err = time.Now(), nil
+	}
+	if err != nil {
+		at, err = time.Parse(time.DateOnly, *to)
+		if err != nil {
+			return fmt.Errorf("--to must look like %s or %s", time.RFC3339, time.DateOnly)
+		}
+		at = at.AddDate(0, 0, 1).Add(-time.Second)
+	}
+
+	plan, err := planRestore(ctx, c.storage, c.backups, *username, at, *undo)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.out, "restoring %s from the snapshot at %s\n", plan.Username, plan.Snapshot.Format(time.RFC3339))
+	for _, change := range plan.UserInfoChanges {
+		_, _ = fmt.Fprintf(c.out, "  %s\n", change)
+	}
+	for _, r := range plan.Removed {
+		_, _ = fmt.Fprintf(c.out, "- %s\n", strings.Join(r, ","))
+	}
+	for _, r := range plan.Added {
+		_, _ = fmt.Fprintf(c.out, "+ %s\n", strings.Join(r, ","))
+	}
+	if len(plan.UserInfoChanges) == 0 && len(plan.Removed) == 0 && len(plan.Added) == 0 {
+		_, _ = fmt.Fprintln(c.out, "nothing would change")
+		return nil
+	}
+	if *dryRun {
+		return nil
+	}
+
+	if !*yes {
+		_, _ = fmt.Fprint(c.out, "restore? type yes to confirm: ")
+		line, _ := readLine(c.in)
+		if line != "yes" {
+			return errors.New("not confirmed")
+		}
+	}
+	err = restoreUser(ctx, c.storage, c.backups, plan, time.Now())
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.out, "restored %s, restore --undo puts back the files from before\n", plan.Username)
+	return nil
+}
+
+// askRepair shows the issue and asks what to do, anything but fix, remove or quarantine leaves the row alone
+func (c *cli) askRepair(username string, issue fsckIssue) fsckAction {
+	_, _ = fmt.Fprintf(c.out, "%s row %d: %s\n  %s\n", username, issue.Row, strings.Join(issue.Problems, ", "), strings.Join(issue.Record, ","))
+	var options []string
+	if issue.Fix != nil {
+		_, _ = fmt.Fprintf(c.out, "  fix: %s\n", strings.Join(issue.Fix, ","))
+		options = append(options, "[f]ix")
+	}
+	if issue.Drop {
+		options = append(options, "[r]emove it")
+	}
+	options = append(options, "[q]uarantine")
+	_, _ = fmt.Fprintf(c.out, "%s or [l]eave? ", strings.Join(options, ", "))
+
+	answer, _ := readLine(c.in)
+	switch strings.ToLower(answer) {
+	case "f", "fix":
+		return fsckFix
+	case "r", "remove":
+		return fsckDrop
+	case "q", "quarantine":
+		return fsckQuarantine
+	}
+	return fsckLeave
+}
+
+// formErrors joins an entry form's errors, in a stable order
+func formErrors(form entryForm) error {
+	var errs []error
+	for _, field := range []string{"date", "duration", "effort"} {
+		if msg, ok := form.Errors[field]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, msg))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readLine reads a single trimmed line, without buffering past it
+func readLine(r io.Reader) (string, error) {
+	var b strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			b.WriteByte(buf[0])
+		}
+		if err != nil {
+			if b.Len() > 0 && errors.Is(err, io.EOF) {
+				break
+			}
+			return "", err
+		}
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func (c *cli) notify(ctx context.Context, args []string) error {
+	fs := c.flagSet("notify")
+	username := fs.String("user", "", "only this user, default everyone")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var kind notificationKind
+	switch fs.Arg(0) {
+	case "reminders":
+		kind = notifyReminder
+	case "digest":
+		kind = notifyDigest
+	default:
+		return fmt.Errorf("notify needs reminders or digest\n%s", commandUsage)
+	}
+
+	sent, err := sendNotifications(ctx, c.storage, c.config.SMTP, kind, *username, time.Now())
+	for _, u := range sent {
+		_, _ = fmt.Fprintf(c.out, "sent %s to %s\n", kind, u)
+	}
+	return err
+}
+
+func (c *cli) configPrint(args []string) error {
+	fs := c.flagSet("config print")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(c.out)
+	enc.SetIndent(2)
+	err := enc.Encode(c.config.redacted())
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_runCommand(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+
+	run := func(stdin string, args ...string) (string, error) {
+		var out bytes.Buffer
+		err := runCommand(ctx, &cli{storage: storage, in: strings.NewReader(stdin), out: &out}, args)
+		return out.String(), err
+	}
+
+	_, err := run("", "user", "add", "--username", "alice", "--password", "hunter22", "--resting-heart-rate", "62", "--dob", "1985-03-04", "--no-input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = run("", "user", "add", "--username", "alice", "--password", "hunter22", "--resting-heart-rate", "62", "--dob", "1985-03-04", "--no-input")
+	if err == nil {
+		t.Errorf("adding alice twice should fail")
+	}
+	_, err = run("", "user", "add", "--username", "bob", "--no-input")
+	if err == nil {
+		t.Errorf("missing fields with --no-input should fail")
+	}
+
+	_, err = run("", "entry", "add", "--user", "alice", "--date", "2024-06-17", "--duration", "1h 15m", "--type", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = run("", "entry", "add", "--user", "alice", "--date", "2024-06-16", "--duration", "soon")
+	if err == nil || !strings.Contains(err.Error(), "duration") {
+		t.Errorf("bad duration error = %v", err)
+	}
+
+	out, err := run("", "entry", "list", "--user", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "2024-06-17  0      1h15m     80%     run") {
+		t.Errorf("entry list = %q, missing the run with the default effort", out)
+	}
+
+	_, err = run("", "entry", "delete", "--user", "alice", "--date", "2024-06-17", "--index", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 0 {
+		t.Errorf("entry delete left %v", days)
+	}
+
+	_, err = run("alice\n", "user", "delete", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = run("", "user", "list")
+	if err != nil || out != "" {
+		t.Errorf("user list = %q, %v, want nothing", out, err)
+	}
+}
+
+func Test_userInfoFromIO(t *testing.T) {
+	var w bytes.Buffer
+	// invalid answers are asked again
+	r := strings.NewReader("bad/name\nalice\nshort\nhunter22\nfast\n62\n3000-01-01\n1985-03-04\n")
+	u, err := userInfoFromIO(r, &w, userInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Username != "alice" || u.RestingHeartrate != 62 || !u.DateOfBirth.Equal(time.Date(1985, 3, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("userInfoFromIO() = %+v", u)
+	}
+	if u.Password == "hunter22" || u.Password == "" {
+		t.Errorf("password wasn't hashed")
+	}
+	if strings.Count(w.String(), "invalid") != 4 {
+		t.Errorf("expected 4 invalid messages, got %q", w.String())
+	}
+
+	// preset fields aren't prompted for
+	u, err = userInfoFromIO(strings.NewReader(""), &w, userInput{
+		Username:         "bob",
+		Password:         "hunter22",
+		RestingHeartrate: "55",
+		DateOfBirth:      "1990-01-01",
+	})
+	if err != nil || u.Username != "bob" {
+		t.Errorf("userInfoFromIO() = %+v, %v", u, err)
+	}
+
+	_, err = userInfoFromIO(strings.NewReader(""), &w, userInput{Username: "bob", RestingHeartrate: "500"})
+	if err == nil {
+		t.Errorf("expected an error for missing password")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileEnv names the config file when --config isn't given
+const configFileEnv = "CONFIG_FILE"
+
+// Config is everything that can be set, from defaults, then the config file, then environment variables, then flags
+type Config struct {
+	LogLevel       string        `yaml:"log_level"`
+	JWTSecret      string        `yaml:"jwt_secret"`
+	MetricsToken   string        `yaml:"metrics_token"`   // no /metrics if empty
+	TracesExporter string        `yaml:"traces_exporter"` // otlp, console or none, see setupTracing
+	Storage        storageConfig `yaml:"storage"`
+	Serve          serveOptions  `yaml:"serve"`
+	SMTP           mailer        `yaml:"smtp"`
+}
+
+// storageConfig is where user data and backups are kept, in local directories or an S3 bucket
+type storageConfig struct {
+	Local          bool   `yaml:"local"`
+	LocalDir       string `yaml:"local_dir"`
+	LocalBackupDir string `yaml:"local_backup_dir"`
+	Bucket         string `yaml:"bucket"`
+	DataPrefix     string `yaml:"data_prefix"`
+	BackupPrefix   string `yaml:"backup_prefix"`
+	Endpoint       string `yaml:"endpoint"` // for S3-compatible stores, see S3Storage
+	Region         string `yaml:"region"`
+	UsePathStyle   bool   `yaml:"use_path_style"`
+	Layout         string `yaml:"layout"` // of new users' entries, file or segmented, see dataLayout
+}
+
+func (c storageConfig) s3Storage(prefix string) S3Storage {
+	return S3Storage{
+		Bucket:       c.Bucket,
+		Prefix:       prefix,
+		Endpoint:     c.Endpoint,
+		Region:       c.Region,
+		UsePathStyle: c.UsePathStyle,
+	}
+}
+
+func defaultConfig() Config {
+	return Config{
+		LogLevel: "debug",
+		Storage: storageConfig{
+			LocalDir:       localDataDir,
+			LocalBackupDir: localBackupDir,
+			Bucket:         s3Bucket,
+			DataPrefix:     s3DataPrefix,
+			BackupPrefix:   s3BackupPrefix,
+			Layout:         string(layoutFile),
+		},
+		Serve: serveOptions{
+			Addr:            ":8080",
+			ReadTimeout:     30 * time.Second,
+			WriteTimeout:    time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		SMTP: mailer{
+			From:    "Activity Tracker <activity-tracker@localhost>",
+			BaseURL: "http://localhost:8080",
+		},
+	}
+}
+
+// loadConfig layers the file, if there is one, and then the environment over the defaults
+// flags are applied by the caller, and then it should be validated
+func loadConfig(fileName string, getenv func(string) string) (Config, error) {
+	cfg := defaultConfig()
+	if fileName == "" {
+		fileName = getenv(configFileEnv)
+	}
+	if fileName != "" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config: %w", err)
+		}
+		defer safeClose(context.Background(), f, "config file")
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("parsing config %s: %w", fileName, err)
+		}
+	}
+	err := cfg.applyEnv(getenv)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// applyEnv sets whatever is in the environment, the names are the ones used before there was a config file
+func (c *Config) applyEnv(getenv func(string) string) error {
+	strs := map[string]*string{
+		"LOG_LEVEL":        &c.LogLevel,
+		"JWT_SECRET":       &c.JWTSecret,
+		"METRICS_TOKEN":    &c.MetricsToken,
+		tracesExporterEnv:  &c.TracesExporter,
+		"DATA_DIR":         &c.Storage.LocalDir,
+		"BACKUP_DIR":       &c.Storage.LocalBackupDir,
+		"S3_BUCKET":        &c.Storage.Bucket,
+		"S3_DATA_PREFIX":   &c.Storage.DataPrefix,
+		"S3_BACKUP_PREFIX": &c.Storage.BackupPrefix,
+		"S3_ENDPOINT":      &c.Storage.Endpoint,
+		"S3_REGION":        &c.Storage.Region,
+		"DATA_LAYOUT":      &c.Storage.Layout,
+		"LISTEN_ADDR":      &c.Serve.Addr,
+		"TLS_CERT":         &c.Serve.TLSCert,
+		"TLS_KEY":          &c.Serve.TLSKey,
+		"SMTP_ADDR":        &c.SMTP.Addr,
+		"SMTP_USERNAME":    &c.SMTP.Username,
+		"SMTP_PASSWORD":    &c.SMTP.Password,
+		"SMTP_FROM":        &c.SMTP.From,
+		"BASE_URL":         &c.SMTP.BaseURL,
+	}
+	for name, s := range strs {
+		if v := getenv(name); v != "" {
+			*s = v
+		}
+	}
+	bools := map[string]*bool{
+		"LOCAL_FILE":        &c.Storage.Local,
+		"S3_USE_PATH_STYLE": &c.Storage.UsePathStyle,
+	}
+	for name, b := range bools {
+		if v := getenv(name); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("bad %s: %w", name, err)
+			}
+			*b = parsed
+		}
+	}
+	return nil
+}
+
+// validate checks what can be checked without connecting to anything
+func (c *Config) validate() error {
+	var errs []error
+	if _, err := c.slogLevel(); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	switch c.TracesExporter {
+	case "", "none", "otlp", "console", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("traces_exporter: unknown %q, expected otlp, console or none", c.TracesExporter))
+	}
+	if c.Storage.Local && (c.Storage.LocalDir == "" || c.Storage.LocalBackupDir == "") {
+		errs = append(errs, errors.New("storage: local_dir and local_backup_dir are needed for local storage"))
+	}
+	if !c.Storage.Local && c.Storage.Bucket == "" {
+		errs = append(errs, errors.New("storage: bucket is needed unless storage is local"))
+	}
+	if u, err := url.Parse(c.Storage.Endpoint); c.Storage.Endpoint != "" && (err != nil || u.Host == "") {
+		errs = append(errs, fmt.Errorf("storage.endpoint: %q isn't an absolute url", c.Storage.Endpoint))
+	}
+	switch dataLayout(c.Storage.Layout) {
+	case layoutFile, layoutSegmented:
+	default:
+		errs = append(errs, fmt.Errorf("storage.layout: unknown %q, expected file or segmented", c.Storage.Layout))
+	}
+	if err := c.Serve.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("serve: %w", err))
+	}
+	if _, err := mail.ParseAddress(c.SMTP.From); err != nil {
+		errs = append(errs, fmt.Errorf("smtp.from: %w", err))
+	}
+	c.SMTP.BaseURL = strings.TrimSuffix(c.SMTP.BaseURL, "/")
+	if u, err := url.Parse(c.SMTP.BaseURL); err != nil || u.Host == "" {
+		errs = append(errs, fmt.Errorf("smtp.base_url: %q isn't an absolute url", c.SMTP.BaseURL))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) slogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+// requireJWTSecret is for the commands that log people in, the rest don't need it
+func (c *Config) requireJWTSecret() error {
+	if c.JWTSecret == "" {
+		return errors.New("jwt_secret (or JWT_SECRET) is needed to sign sessions")
+	}
+	return nil
+}
+
+// redacted is safe to print, secrets that are set are replaced
+func (c Config) redacted() Config {
+	for _, s := range []*string{&c.JWTSecret, &c.MetricsToken, &c.SMTP.Password} {
+		if *s != "" {
+			*s = "REDACTED"
+		}
+	}
+	return c
+}
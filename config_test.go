@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_loadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "tracker.yaml")
+	err := os.WriteFile(file, []byte(`
+log_level: info
+jwt_secret: from-the-file
+storage:
+  local: true
+  local_dir: /var/lib/tracker
+serve:
+  addr: 127.0.0.1:9000
+  write_timeout: 2m
+smtp:
+  addr: mail:25
+  base_url: https://tracker.example.com/
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"JWT_SECRET":        "from-the-env",
+		"SMTP_PASSWORD":     "hunter22",
+		"S3_ENDPOINT":       "http://minio:9000",
+		"S3_USE_PATH_STYLE": "true",
+	}
+
+	cfg, err := loadConfig(file, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultConfig()
+	want.LogLevel = "info"
+	want.JWTSecret = "from-the-env"
+	want.Storage.Local = true
+	want.Storage.LocalDir = "/var/lib/tracker"
+	want.Storage.Endpoint = "http://minio:9000"
+	want.Storage.UsePathStyle = true
+	want.Serve.Addr = "127.0.0.1:9000"
+	want.Serve.WriteTimeout = 2 * time.Minute
+	want.SMTP.Addr = "mail:25"
+	want.SMTP.Password = "hunter22"
+	want.SMTP.BaseURL = "https://tracker.example.com"
+	if cfg != want {
+		t.Errorf("loadConfig() = %+v\nwant %+v", cfg, want)
+	}
+
+	// CONFIG_FILE is used without --config, which is checked strictly
+	err = os.WriteFile(file, []byte("jwt_secrte: typo\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadConfig("", func(name string) string { return map[string]string{configFileEnv: file}[name] })
+	if err == nil || !strings.Contains(err.Error(), "jwt_secrte") {
+		t.Errorf("loadConfig() with an unknown field error = %v", err)
+	}
+}
+
+func Test_Config_validate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.LogLevel = "loud"
+	cfg.Storage.Bucket = ""
+	cfg.Storage.Endpoint = "minio:9000"
+	cfg.Storage.Layout = "sharded"
+	cfg.Serve.TLSCert = "cert.pem"
+	cfg.SMTP.BaseURL = "tracker.example.com"
+	err := cfg.validate()
+	if err == nil {
+		t.Fatal("validate() should fail")
+	}
+	for _, want := range []string{"log_level", "storage: bucket", "storage.endpoint", "storage.layout", "serve", "smtp.base_url"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validate() = %v, missing %s", err, want)
+		}
+	}
+}
+
+func Test_configPrint(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.JWTSecret = "extremely-secret"
+	var out bytes.Buffer
+	err := runCommand(context.Background(), &cli{config: cfg, out: &out}, []string{"config", "print"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if strings.Contains(got, "extremely-secret") || !strings.Contains(got, "jwt_secret: REDACTED") {
+		t.Errorf("secret not redacted in\n%s", got)
+	}
+	if !strings.Contains(got, "write_timeout: 1m0s") || !strings.Contains(got, "bucket: activity-tracker-lambda-artifacts") {
+		t.Errorf("config print =\n%s", got)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the data file starts with a version line, then a header naming the columns
+//
+//	#activity-tracker-data v2
+//	date,duration,effort,description,type,start
+//	2024-06-17,45m0s,0.80,morning,run,07:00
+//
+// version 1 has neither, just rows of 4 to 6 columns in dataColumns order
+const (
+	dataFormatVersion = 2
+	dataFormatPrefix  = "#activity-tracker-data v"
+)
+
+// dataColumns is the order toCSVRecords writes in, new columns go on the end
+var dataColumns = []string{"date", "duration", "effort", "description", "type", "start"}
+
+// dataFormats read the records (after the version line) of each version into dataColumns order
+var dataFormats = map[int]func(r *csv.Reader) ([][]string, error){
+	1: readDataV1,
+	2: readDataV2,
+}
+
+// decodeData reads a data file of any version, returning the version and the records in dataColumns order
+func decodeData(r io.Reader) (int, [][]string, error) {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(1)
+	if errors.Is(err, io.EOF) {
+		// a new file is the current version
+		return dataFormatVersion, nil, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading data: %w", err)
+	}
+
+	version := 1
+	if first[0] == '#' {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, nil, fmt.Errorf("reading data version: %w", err)
+		}
+		version, err = parseDataVersion(line)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	read, ok := dataFormats[version]
+	if !ok {
+		return 0, nil, fmt.Errorf("data version %d is newer than this understands (%d)", version, dataFormatVersion)
+	}
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+	records, err := read(reader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading csv: %w", err)
+	}
+	return version, records, nil
+}
+
+func parseDataVersion(line string) (int, error) {
+	s, ok := strings.CutPrefix(strings.TrimSpace(line), dataFormatPrefix)
+	if !ok {
+		return 0, fmt.Errorf("unrecognized data file header %q", strings.TrimSpace(line))
+	}
+	version, err := strconv.Atoi(s)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("bad data version %q", s)
+	}
+	return version, nil
+}
+
+// readDataV1 rows are already in dataColumns order, older rows don't have the type or start columns
+func readDataV1(r *csv.Reader) ([][]string, error) {
+	records, err := r.ReadAll()
+	for i, rec := range records {
+		if len(rec) >= 4 && len(rec) < len(dataColumns) {
+			records[i] = append(rec, make([]string, len(dataColumns)-len(rec))...)
+		}
+	}
+	return records, err
+}
+
+// readDataV2 maps the columns by the header, so they can be in any order
+func readDataV2(r *csv.Reader) ([][]string, error) {
+	records, err := r.ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+
+	header := records[0]
+	var unknown []string
+	for _, h := range header {
+		if !slices.Contains(dataColumns, h) {
+			unknown = append(unknown, h)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown data columns %v", unknown)
+	}
+
+	rows := make([][]string, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		if len(rec) != len(header) {
+			// left alone for fsck to report
+			rows = append(rows, rec)
+			continue
+		}
+		row := make([]string, len(dataColumns))
+		for i, h := range header {
+			row[slices.Index(dataColumns, h)] = rec[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// encodeData writes the records in the current version
+func encodeData(w io.Writer, records [][]string) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", dataFormatPrefix, dataFormatVersion)
+	if err != nil {
+		return err
+	}
+	return csv.NewWriter(w).WriteAll(append([][]string{dataColumns}, records...))
+}
+
+// migrationBackupFileName is where the original data file is kept when it's migrated from version
+func migrationBackupFileName(version int) string {
+	return fmt.Sprintf("activity-tracker-data.v%d.bak.csv", version)
+}
+
+// readDataRecords reads a user's data file, migrating it to the current version on first access
+func readDataRecords(ctx context.Context, getFileHandler fileHandlerFunc, username string) ([][]string, error) {
+	_, records, err := migrateUserData(ctx, getFileHandler, username, false)
+	return records, err
+}
+
+// migrateUserData upgrades the data file to the current version, keeping the original as a backup
+// it returns the version the file was, and its records
+func migrateUserData(ctx context.Context, getFileHandler fileHandlerFunc, username string, dryRun bool) (int, [][]string, error) {
+	f, err := getFileHandler(ctx, username, userDataFileName)
+	if err != nil {
+		return 0, nil, err
+	}
+	raw, err := io.ReadAll(f)
+	safeClose(ctx, f, "read data")
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading data: %w", err)
+	}
+	if isSegmentedData(raw) {
+		return 0, nil, errSegmentedLayout
+	}
+
+	version, records, err := decodeTraced(ctx, raw)
+	if err != nil || version == dataFormatVersion || dryRun {
+		return version, records, err
+	}
+
+	backup, err := getFileHandler(ctx, username, migrationBackupFileName(version))
+	if err != nil {
+		return version, nil, err
+	}
+	_, err = backup.Write(raw)
+	if err != nil {
+		safeClose(ctx, backup, "migration backup")
+		return version, nil, fmt.Errorf("failed to back up data: %w", err)
+	}
+	err = backup.Close()
+	if err != nil {
+		return version, nil, fmt.Errorf("failed to back up data: %w", err)
+	}
+
+	return version, records, writeDataRecords(ctx, getFileHandler, username, records)
+}
+
+// decodeTraced is decodeData in a span
+func decodeTraced(ctx context.Context, raw []byte) (int, [][]string, error) {
+	_, span := tracer.Start(ctx, "decodeData", trace.WithAttributes(attribute.Int("bytes", len(raw))))
+	version, records, err := decodeData(bytes.NewReader(raw))
+	span.SetAttributes(attribute.Int("data.version", version), attribute.Int("records", len(records)))
+	endSpan(span, err)
+	return version, records, err
+}
+
+// writeDataRecords replaces a user's data file, in the current version
+func writeDataRecords(ctx context.Context, getFileHandler fileHandlerFunc, username string, records [][]string) error {
+	f, err := getFileHandler(ctx, username, userDataFileName)
+	if err != nil {
+		return err
+	}
+	if vf, ok := f.(versionedFile); ok {
+		daysCache.forget(vf.fileID())
+	}
+
+	// buffered so there is always a write, even if there are no records left
+	var b bytes.Buffer
+	err = encodeData(&b, records)
+	if err == nil {
+		_, err = f.Write(b.Bytes())
+	}
+	if err != nil {
+		safeClose(ctx, f, "write data")
+		return fmt.Errorf("failed to write data: %w", err)
+	}
+	return f.Close()
+}
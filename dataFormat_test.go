@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_decodeData(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantVersion int
+		want        [][]string
+		wantErr     bool
+	}{
+		{
+			name:        "empty",
+			data:        "",
+			wantVersion: dataFormatVersion,
+		},
+		{
+			name:        "v1",
+			data:        "2024-06-17,45m0s,0.80,morning\n2024-06-16,30m0s,0.50,,walk,07:00\n",
+			wantVersion: 1,
+			want: [][]string{
+				{"2024-06-17", "45m0s", "0.80", "morning", "", ""},
+				{"2024-06-16", "30m0s", "0.50", "", "walk", "07:00"},
+			},
+		},
+		{
+			name:        "v2",
+			data:        "#activity-tracker-data v2\ndate,duration,effort,description,type,start\n2024-06-17,45m0s,0.80,morning,run,07:00\n",
+			wantVersion: 2,
+			want:        [][]string{{"2024-06-17", "45m0s", "0.80", "morning", "run", "07:00"}},
+		},
+		{
+			name:        "v2 columns in any order",
+			data:        "#activity-tracker-data v2\neffort,date,duration,description\n0.80,2024-06-17,45m0s,morning\n",
+			wantVersion: 2,
+			want:        [][]string{{"2024-06-17", "45m0s", "0.80", "morning", "", ""}},
+		},
+		{
+			name:    "v2 unknown column",
+			data:    "#activity-tracker-data v2\ndate,duration,effort,description,heartrate\n",
+			wantErr: true,
+		},
+		{
+			name:    "newer version",
+			data:    "#activity-tracker-data v99\n",
+			wantErr: true,
+		},
+		{
+			name:    "not a data file",
+			data:    "# some notes\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, got, err := decodeData(strings.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if version != tt.wantVersion {
+				t.Errorf("decodeData() version = %d, want %d", version, tt.wantVersion)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_migrateUserData(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+
+	v1 := "2024-06-17,45m0s,0.80,morning\n"
+	err := writeRecords(ctx, storage.Open, "alice", userDataFileName, [][]string{{"2024-06-17", "45m0s", "0.80", "morning"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, _, err := migrateUserData(ctx, storage.Open, "alice", true)
+	if err != nil || version != 1 {
+		t.Fatalf("dry run = v%d, %v", version, err)
+	}
+
+	// first access migrates
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || days[0].Entries[0].Description != "morning" {
+		t.Errorf("readDays() = %+v", days)
+	}
+
+	read := func(fileName string) string {
+		f, err := storage.Open(ctx, "alice", fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer safeClose(ctx, f, "test")
+		var b bytes.Buffer
+		_, err = b.ReadFrom(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	want := "#activity-tracker-data v2\ndate,duration,effort,description,type,start\n2024-06-17,45m0s,0.80,morning,,\n"
+	if got := read(userDataFileName); got != want {
+		t.Errorf("migrated file = %q, want %q", got, want)
+	}
+	if got := read(migrationBackupFileName(1)); got != v1 {
+		t.Errorf("backup = %q, want %q", got, v1)
+	}
+
+	version, _, err = migrateUserData(ctx, storage.Open, "alice", false)
+	if err != nil || version != dataFormatVersion {
+		t.Errorf("second migration = v%d, %v", version, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// dayCacheSize is how many files are kept parsed, the least recently used are dropped past it
+const dayCacheSize = 1000
+
+// daysCache keeps each user's parsed data file between requests, and lambda invocations while it's warm
+// in the segmented layout it's each segment and append that's kept, see readSegmentFile
+var daysCache = newDayCache(dayCacheSize, time.Now)
+
+type cachedDays struct {
+	version   string // from readIfChanged
+	days      []DayLog
+	segmented bool       // the file is the segmented layout's marker, there are no days
+	covers    []string   // a segment's covered appends
+	records   [][]string // a segment or append's rows
+	used      time.Time
+}
+
+// dayCache is keyed by fileID, entries are only used while the file is still at their version
+type dayCache struct {
+	mu      sync.Mutex
+	size    int
+	now     func() time.Time
+	entries map[string]cachedDays
+}
+
+func newDayCache(size int, now func() time.Time) *dayCache {
+	return &dayCache{size: size, now: now, entries: make(map[string]cachedDays)}
+}
+
+// get returns the cached version, or "" when there isn't one
+func (c *dayCache) get(id string) (string, []DayLog, bool) {
+	e := c.use(id)
+	return e.version, cloneDays(e.days), e.segmented
+}
+
+// getRecords is get for a segment or append
+func (c *dayCache) getRecords(id string) (string, []string, [][]string) {
+	e := c.use(id)
+	return e.version, e.covers, cloneRecords(e.records)
+}
+
+func (c *dayCache) use(id string) cachedDays {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return cachedDays{}
+	}
+	e.used = c.now()
+	c.entries[id] = e
+	return e
+}
+
+func (c *dayCache) put(id, version string, days []DayLog) {
+	c.add(id, cachedDays{version: version, days: cloneDays(days)})
+}
+
+// putSegmented remembers the file is the segmented layout's marker
+func (c *dayCache) putSegmented(id, version string) {
+	c.add(id, cachedDays{version: version, segmented: true})
+}
+
+func (c *dayCache) putRecords(id, version string, covers []string, records [][]string) {
+	c.add(id, cachedDays{version: version, covers: slices.Clone(covers), records: cloneRecords(records)})
+}
+
+func (c *dayCache) add(id string, e cachedDays) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.used = c.now()
+	c.entries[id] = e
+	for len(c.entries) > c.size {
+		oldest := ""
+		for k, e := range c.entries {
+			if oldest == "" || e.used.Before(c.entries[oldest].used) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+// forget is for writes, the version would catch it but this doesn't depend on timestamp resolution
+func (c *dayCache) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, id)
+}
+
+// cloneDays copies the entries too, callers are free to change what they get
+func cloneDays(days []DayLog) []DayLog {
+	if days == nil {
+		return nil
+	}
+	clone := slices.Clone(days)
+	for i := range clone {
+		clone[i].Entries = slices.Clone(clone[i].Entries)
+	}
+	return clone
+}
+
+func cloneRecords(records [][]string) [][]string {
+	if records == nil {
+		return nil
+	}
+	clone := slices.Clone(records)
+	for i := range clone {
+		clone[i] = slices.Clone(clone[i])
+	}
+	return clone
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_readDays_cached(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	s3 := newFakeS3(t, "tracker")
+	backends := map[string]Storage{
+		"local": LocalStorage{Dir: t.TempDir()},
+		"s3":    s3.storage("data/"),
+	}
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: time.Hour, Effort: 0.5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			days, err := readDays(ctx, storage.Open, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// callers can change what they get without changing the cache
+			days[0].Entries[0].Duration = time.Minute
+			days[0].Entries = nil
+
+			before := s3.downloads()
+			days, err = readDays(ctx, storage.Open, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(days) != 1 || len(days[0].Entries) != 1 || days[0].Entries[0].Duration != time.Hour {
+				t.Errorf("cached readDays() = %+v", days)
+			}
+			if got := s3.downloads(); got != before {
+				t.Errorf("an unchanged file was downloaded %d more times", got-before)
+			}
+
+			// a write is seen straight away
+			err = addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: 30 * time.Minute, Effort: 0.8})
+			if err != nil {
+				t.Fatal(err)
+			}
+			days, err = readDays(ctx, storage.Open, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(days) != 1 || len(days[0].Entries) != 2 {
+				t.Errorf("readDays() after a write = %+v", days)
+			}
+		})
+	}
+}
+
+func Test_dayCache_evicts(t *testing.T) {
+	now := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	c := newDayCache(2, func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+	c.put("a", "1", nil)
+	c.put("b", "1", nil)
+	c.get("a")
+	c.put("c", "1", nil)
+	for id, want := range map[string]string{"a": "1", "b": "", "c": "1"} {
+		if got, _, _ := c.get(id); got != want {
+			t.Errorf("get(%s) version = %q, want %q", id, got, want)
+		}
+	}
+}
+
+// Benchmark_readDays is two years of daily entries read from each backend, every time vs when it hasn't changed
+func Benchmark_readDays(b *testing.B) {
+	ctx := context.Background()
+	s3 := newFakeS3(b, "tracker")
+	backends := map[string]Storage{
+		"local": LocalStorage{Dir: b.TempDir()},
+		"s3":    s3.storage("data/"),
+	}
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var days []DayLog
+	for i := range 730 {
+		days = append(days, DayLog{
+			Date:    start.AddDate(0, 0, 729-i),
+			Entries: []DayEntry{{Duration: 45 * time.Minute, Effort: 0.6, Description: fmt.Sprintf("run %d", i)}},
+		})
+	}
+
+	for name, storage := range backends {
+		err := writeDays(ctx, storage.Open, "alice", days)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name+"/uncached", func(b *testing.B) {
+			for range b.N {
+				_, err := readDaysUncached(ctx, storage.Open, "alice")
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/cached", func(b *testing.B) {
+			for range b.N {
+				_, err := readDays(ctx, storage.Open, "alice")
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Benchmark_s3Client is loading the AWS config for each request vs once per process
+func Benchmark_s3Client(b *testing.B) {
+	ctx := context.Background()
+	storage := newFakeS3(b, "tracker").storage("data/")
+	b.Run("new", func(b *testing.B) {
+		for range b.N {
+			_, err := storage.newClient(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reused", func(b *testing.B) {
+		for range b.N {
+			_, err := storage.client(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
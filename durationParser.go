@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// 30m, 1h 30m, 1.5 hours, 1 hr 30 min, 90
+	durationPartsRegex = regexp.MustCompile(`^(?:\d+(?:\.\d+)?\s*[a-z]*\s*)+$`)
+	durationPartRegex  = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*([a-z]*)`)
+
+	// 1:15
+	clockDurationRegex = regexp.MustCompile(`^(\d+):(\d{2})$`)
+
+	// 9:00-9:45, 9am - 10:15am, 21:30 to 22:00
+	timeRangeRegex = regexp.MustCompile(`^(.+?)\s*(?:-|–|to)\s*(.+)$`)
+	clockTimeRegex = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?\s*(am|pm)?$`)
+)
+
+var durationUnits = map[string]time.Duration{
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+}
+
+const maxEntryDuration = 24 * time.Hour
+
+// parseFlexibleDuration parses the many ways people write how long something took
+// e.g. "30 min", "1:15", "1h 30m", "1.5 hours", "45" (minutes), or a time range like "9:00-9:45"
+func parseFlexibleDuration(str string) (time.Duration, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	if str == "" {
+		return 0, errors.New("duration is required")
+	}
+
+	d, err := parseDurationValue(str)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("duration must be more than zero")
+	}
+	if d > maxEntryDuration {
+		return 0, fmt.Errorf("duration must be less than %s", sumStr(maxEntryDuration))
+	}
+	return d, nil
+}
+
+// parseStartTime returns the start of the day offset if the duration was written as a time range
+func parseStartTime(str string) (time.Duration, bool) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	m := timeRangeRegex.FindStringSubmatch(str)
+	if m == nil || clockDurationRegex.MatchString(str) || durationPartsRegex.MatchString(str) {
+		return 0, false
+	}
+	start, _, err := parseTimeRange(m[1], m[2])
+	if err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+func parseDurationValue(str string) (time.Duration, error) {
+	if d, err := time.ParseDuration(str); err == nil {
+		return d, nil
+	}
+
+	if m := clockDurationRegex.FindStringSubmatch(str); m != nil {
+		hours, _ := strconv.Atoi(m[1])
+		minutes, _ := strconv.Atoi(m[2])
+		if minutes >= 60 {
+			return 0, fmt.Errorf("%q has more than 59 minutes", str)
+		}
+		return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+	}
+
+	if durationPartsRegex.MatchString(str) {
+		return parseDurationParts(str)
+	}
+
+	if m := timeRangeRegex.FindStringSubmatch(str); m != nil {
+		start, end, err := parseTimeRange(m[1], m[2])
+		if err != nil {
+			return 0, err
+		}
+		return end - start, nil
+	}
+
+	return 0, fmt.Errorf("could not understand duration %q, try something like 30m, 1:15 or 9:00-9:45", str)
+}
+
+func parseDurationParts(str string) (time.Duration, error) {
+	parts := durationPartRegex.FindAllStringSubmatch(str, -1)
+
+	var total time.Duration
+	for _, p := range parts {
+		value, err := strconv.ParseFloat(p[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad number %q", p[1])
+		}
+
+		unit, ok := durationUnits[p[2]]
+		if p[2] == "" && len(parts) == 1 {
+			// a bare number is minutes
+			unit, ok = time.Minute, true
+		}
+		if !ok {
+			return 0, fmt.Errorf("unknown duration unit %q", p[2])
+		}
+		total += time.Duration(value * float64(unit))
+	}
+	return total, nil
+}
+
+// parseTimeRange returns the start and end as offsets from midnight, the end may be the next day
+func parseTimeRange(startStr, endStr string) (time.Duration, time.Duration, error) {
+	start, err := parseClockTime(startStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClockTime(endStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		// e.g. 23:30-00:15
+		end += 24 * time.Hour
+	}
+	return start, end, nil
+}
+
+// parseClockTime returns the time of day as an offset from midnight e.g. 9:45, 21:30, 9am, 9:45pm
+func parseClockTime(str string) (time.Duration, error) {
+	m := clockTimeRegex.FindStringSubmatch(strings.TrimSpace(str))
+	if m == nil {
+		return 0, fmt.Errorf("could not understand time %q", str)
+	}
+
+	hours, _ := strconv.Atoi(m[1])
+	minutes := 0
+	if m[2] != "" {
+		minutes, _ = strconv.Atoi(m[2])
+	}
+
+	switch m[3] {
+	case "am":
+		if hours == 12 {
+			hours = 0
+		}
+	case "pm":
+		if hours != 12 {
+			hours += 12
+		}
+	}
+
+	if hours > 23 || minutes > 59 || (m[3] != "" && m[1] == "0") {
+		return 0, fmt.Errorf("%q is not a valid time", str)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseFlexibleDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "1h30m", want: 90 * time.Minute},
+		{in: "1h 30m", want: 90 * time.Minute},
+		{in: "30 min", want: 30 * time.Minute},
+		{in: "30 Minutes", want: 30 * time.Minute},
+		{in: "1.5 hours", want: 90 * time.Minute},
+		{in: "1 hr 15 mins", want: 75 * time.Minute},
+		{in: "45", want: 45 * time.Minute},
+		{in: "1:15", want: 75 * time.Minute},
+		{in: "9:00-9:45", want: 45 * time.Minute},
+		{in: "9:00 - 10:15", want: 75 * time.Minute},
+		{in: "9am-10:30am", want: 90 * time.Minute},
+		{in: "11:30am to 12:15pm", want: 45 * time.Minute},
+		{in: "23:30-0:15", want: 45 * time.Minute},
+		{in: "", wantErr: true},
+		{in: "soon", wantErr: true},
+		{in: "0m", wantErr: true},
+		{in: "1:75", wantErr: true},
+		{in: "25:00-26:00", wantErr: true},
+		{in: "30 parsecs", wantErr: true},
+		{in: "1 2", wantErr: true},
+		{in: "30h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseFlexibleDuration(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFlexibleDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseFlexibleDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseStartTime(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOk bool
+	}{
+		{in: "9:00-9:45", want: 9 * time.Hour, wantOk: true},
+		{in: "1:30pm - 2pm", want: 13*time.Hour + 30*time.Minute, wantOk: true},
+		{in: "1:15"},
+		{in: "30 min"},
+		{in: "9:00-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := parseStartTime(tt.in)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseStartTime() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ func Recover() echo.MiddlewareFunc {
 		return func(c echo.Context) error {
 			defer func() {
 				if r := recover(); r != nil {
+					panicsRecovered.Inc()
 
 					// extract/convert the recovery information to an error
 					err, ok := r.(error)
@@ -22,11 +23,10 @@ func Recover() echo.MiddlewareFunc {
 						err = fmt.Errorf("%v", r)
 					}
 
-					// determine the stack and print it
+					// determine the stack and log it with the request's attributes
 					stack := make([]byte, stackSize)
 					length := runtime.Stack(stack, true)
-					msg := fmt.Sprintf("[PANIC RECOVER] %v %s\n", err, stack[:length])
-					fmt.Println(msg)
+					loggerFrom(c.Request().Context()).Error("[PANIC RECOVER]", "err", err, "stack", string(stack[:length]))
 
 					// send the error to the echo error handler
 					c.Error(err)
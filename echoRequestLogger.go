@@ -1,24 +1,47 @@
 package main
 
 import (
-	"github.com/labstack/echo/v4"
-	"log/slog"
+	"strconv"
 	"time"
+
+	"github.com/labstack/echo/v4"
 )
 
 // RequestLogger is a custom version of echo.Logger which uses our structured logger
+// it puts a logger with the request id, method and route in the request context, see loggerFrom
 func RequestLogger() echo.MiddlewareFunc {
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(e echo.Context) error {
 
+			// the lambda proxies set the request id, otherwise it's made up here
+			req := e.Request()
+			requestID := req.Header.Get(echo.HeaderXRequestID)
+			if requestID == "" {
+				requestID = newRequestID()
+				req.Header.Set(echo.HeaderXRequestID, requestID)
+			}
+			e.Response().Header().Set(echo.HeaderXRequestID, requestID)
+
+			route := e.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			ctx := withLogAttrs(req.Context(),
+				"request_id", requestID,
+				"method", req.Method,
+				"route", route,
+			)
+			e.SetRequest(req.WithContext(ctx))
+
 			// gather some attributes for the incoming request
-			bytes := e.Request().Header.Get(echo.HeaderContentLength)
+			bytes := req.Header.Get(echo.HeaderContentLength)
 			if bytes == "" {
 				bytes = "0"
 			}
 
-			slog.Info("Incoming Request",
+			loggerFrom(ctx).Info("Incoming Request",
+				"uri", req.RequestURI,
 				"bytes", bytes,
 			)
 
@@ -35,8 +58,11 @@ func RequestLogger() echo.MiddlewareFunc {
 			duration := time.Since(startTime)
 			res := e.Response()
 
+			labels := []string{route, req.Method, strconv.Itoa(res.Status)}
+			httpRequests.WithLabelValues(labels...).Inc()
+			httpDuration.WithLabelValues(labels...).Observe(duration.Seconds())
+
 			// previous format: ${time_rfc3339} [INFO] [${id}] [${method} ${uri} ${status}] ${error}
-			// the default context includes request id, route, method, etc
 			attrs := []any{
 				"status", res.Status,
 				"ms", duration.Milliseconds(),
@@ -46,7 +72,8 @@ func RequestLogger() echo.MiddlewareFunc {
 				attrs = append(attrs, "err", err)
 			}
 
-			slog.Info("Request Status", attrs...)
+			// from the latest request, the auth middleware adds the user to it
+			loggerFrom(e.Request().Context()).Info("Request Status", attrs...)
 
 			return nil // any err is consumed by this middleware
 		}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// readDays reads all of a user's entries, most recent day first
+func readDays(ctx context.Context, getFileHandler fileHandlerFunc, username string) ([]DayLog, error) {
+	return readDaysBetween(ctx, getFileHandler, username, time.Time{}, time.Time{})
+}
+
+// readDaysBetween reads the days from and to (inclusive, either can be zero), most recent first
+// in the segmented layout only those months are fetched
+func readDaysBetween(ctx context.Context, getFileHandler fileHandlerFunc, username string, from, to time.Time) ([]DayLog, error) {
+	days, err := readFileDays(ctx, getFileHandler, username)
+	if errors.Is(err, errSegmentedLayout) {
+		var records [][]string
+		records, err = readSegmented(ctx, getFileHandler, username, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("reading days: %w", err)
+		}
+		days = daysFromRecords(ctx, records)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if from.IsZero() && to.IsZero() {
+		return days, nil
+	}
+	return slices.DeleteFunc(days, func(d DayLog) bool {
+		return !from.IsZero() && d.Date.Before(from) || !to.IsZero() && d.Date.After(to)
+	}), nil
+}
+
+// readFileDays reads the data file's days, errSegmentedLayout if they're not in it
+// they're cached, and only downloaded and parsed again when the file has changed
+func readFileDays(ctx context.Context, getFileHandler fileHandlerFunc, username string) ([]DayLog, error) {
+	f, err := getFileHandler(ctx, username, userDataFileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading days: %w", err)
+	}
+	defer safeClose(ctx, f, "read days")
+	vf, ok := f.(versionedFile)
+	if !ok {
+		return readDaysUncached(ctx, getFileHandler, username)
+	}
+
+	id := vf.fileID()
+	cachedVersion, cached, segmented := daysCache.get(id)
+	version, err := vf.readIfChanged(cachedVersion)
+	if errors.Is(err, errNotModified) {
+		dayCacheResults.WithLabelValues("hit").Inc()
+		if segmented {
+			return nil, errSegmentedLayout
+		}
+		return cached, nil
+	}
+	dayCacheResults.WithLabelValues("miss").Inc()
+	if err != nil {
+		return nil, fmt.Errorf("reading days: %w", err)
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading days: %w", err)
+	}
+	if isSegmentedData(raw) {
+		daysCache.putSegmented(id, version)
+		return nil, errSegmentedLayout
+	}
+	format, records, err := decodeTraced(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("reading days: %w", err)
+	}
+	if format != dataFormatVersion {
+		// migrated the long way, it's cached on the next read
+		return readDaysUncached(ctx, getFileHandler, username)
+	}
+
+	days := daysFromRecords(ctx, records)
+	daysCache.put(id, version, days)
+	return days, nil
+}
+
+// readDaysUncached always reads the file, migrating it if needed
+func readDaysUncached(ctx context.Context, getFileHandler fileHandlerFunc, username string) ([]DayLog, error) {
+	records, err := readDataRecords(ctx, getFileHandler, username)
+	if errors.Is(err, errSegmentedLayout) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading days: %w", err)
+	}
+	return daysFromRecords(ctx, records), nil
+}
+
+// addEntry appends an entry, the write is complete when it returns
+func addEntry(ctx context.Context, getFileHandler fileHandlerFunc, username string, date time.Time, entries ...DayEntry) error {
+	day := DayLog{Date: date, Entries: entries}
+	records, err := readDataRecords(ctx, getFileHandler, username)
+	if errors.Is(err, errSegmentedLayout) {
+		err = appendSegmented(ctx, getFileHandler, username, []DayLog{day})
+	} else if err == nil {
+		err = writeDataRecords(ctx, getFileHandler, username, append(records, toCSVRecords([]DayLog{day})...))
+	}
+	if err != nil {
+		return err
+	}
+	entriesCreated.Add(float64(len(entries)))
+	return nil
+}
+
+// importDays appends the entries that aren't already there, returning how many were added and skipped
+func importDays(ctx context.Context, getFileHandler fileHandlerFunc, username string, days []DayLog) (int, int, error) {
+	records, err := readDataRecords(ctx, getFileHandler, username)
+	segmented := errors.Is(err, errSegmentedLayout)
+	if segmented {
+		records, err = nil, nil
+		if len(days) > 0 {
+			// only the months being imported into are checked for duplicates
+			sorted := filterDays(days, time.Time{}, time.Time{})
+			records, err = readSegmented(ctx, getFileHandler, username, sorted[0].Date, sorted[len(sorted)-1].Date)
+		}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	seen := make(map[string]bool)
+	for _, r := range toCSVRecords(daysFromRecords(ctx, records)) {
+		seen[strings.Join(r, ",")] = true
+	}
+
+	var toAdd []DayLog
+	added, skipped := 0, 0
+	for _, d := range days {
+		day := DayLog{Date: d.Date}
+		for _, e := range d.Entries {
+			key := strings.Join(toCSVRecords([]DayLog{{Date: d.Date, Entries: []DayEntry{e}}})[0], ",")
+			if seen[key] {
+				skipped++
+				continue
+			}
+			seen[key] = true
+			day.Entries = append(day.Entries, e)
+			added++
+		}
+		if len(day.Entries) > 0 {
+			toAdd = append(toAdd, day)
+		}
+	}
+	if len(toAdd) == 0 {
+		return added, skipped, nil
+	}
+
+	if segmented {
+		err = appendSegmented(ctx, getFileHandler, username, toAdd)
+	} else {
+		records = append(records, toCSVRecords(filterDays(toAdd, time.Time{}, time.Time{}))...)
+		err = writeDataRecords(ctx, getFileHandler, username, records)
+	}
+	if err != nil {
+		return added, skipped, err
+	}
+	entriesCreated.Add(float64(added))
+	return added, skipped, nil
+}
+
+// deleteEntry removes the entry at index (as ordered by readDays) on date
+func deleteEntry(ctx context.Context, getFileHandler fileHandlerFunc, username string, date time.Time, index int) (DayEntry, error) {
+	var removed DayEntry
+	err := editRecords(ctx, getFileHandler, username, []time.Time{date}, func(records [][]string) ([][]string, error) {
+		i, err := findRecord(records, date, index)
+		if err != nil {
+			return nil, err
+		}
+		_, removed, _ = parseCSVRecord(records[i])
+		return slices.Delete(records, i, i+1), nil
+	})
+	return removed, err
+}
+
+// updateEntry replaces the entry at index on date, the new entry can be on a different date
+func updateEntry(ctx context.Context, getFileHandler fileHandlerFunc, username string, date time.Time, index int, newDate time.Time, entry DayEntry) error {
+	return editRecords(ctx, getFileHandler, username, []time.Time{date, newDate}, func(records [][]string) ([][]string, error) {
+		i, err := findRecord(records, date, index)
+		if err != nil {
+			return nil, err
+		}
+		record := toCSVRecords([]DayLog{{Date: newDate, Entries: []DayEntry{entry}}})[0]
+		if newDate.Equal(date) {
+			records[i] = record
+			return records, nil
+		}
+		// last on its new day
+		return append(slices.Delete(records, i, i+1), record), nil
+	})
+}
+
+// editRecords replaces a user's rows with what edit returns, it's given at least the rows in dates' months
+// only the rows edit changes are, any that don't parse are left for fsck
+func editRecords(ctx context.Context, getFileHandler fileHandlerFunc, username string, dates []time.Time, edit func([][]string) ([][]string, error)) error {
+	records, err := readDataRecords(ctx, getFileHandler, username)
+	if errors.Is(err, errSegmentedLayout) {
+		var months []string
+		for _, d := range dates {
+			months = append(months, d.Format(monthFormat))
+		}
+		return rewriteMonths(ctx, getFileHandler, username, months, edit)
+	}
+	if err != nil {
+		return err
+	}
+	records, err = edit(records)
+	if err != nil {
+		return err
+	}
+	return writeDataRecords(ctx, getFileHandler, username, records)
+}
+
+// findRecord returns where the entry at index on date is, in the order readDays has them
+func findRecord(records [][]string, date time.Time, index int) (int, error) {
+	n := 0
+	for i, r := range records {
+		d, _, err := parseCSVRecord(r)
+		if err != nil || !d.Equal(date) {
+			continue
+		}
+		if n == index {
+			return i, nil
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%s has no entries", date.Format(time.DateOnly))
+	}
+	return 0, fmt.Errorf("%s has no entry %d", date.Format(time.DateOnly), index)
+}
+
+// writeDays replaces all of a user's entries, oldest first
+func writeDays(ctx context.Context, getFileHandler fileHandlerFunc, username string, days []DayLog) error {
+	return writeDataRecords(ctx, getFileHandler, username, toCSVRecords(filterDays(days, time.Time{}, time.Time{})))
+}
+
+// readRecords reads the raw rows of one of a user's csv files
+func readRecords(ctx context.Context, getFileHandler fileHandlerFunc, username, fileName string) ([][]string, error) {
+	f, err := getFileHandler(ctx, username, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer safeClose(ctx, f, "read records")
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv: %w", err)
+	}
+	return records, nil
+}
+
+// writeRecords replaces one of a user's csv files
+func writeRecords(ctx context.Context, getFileHandler fileHandlerFunc, username, fileName string, records [][]string) error {
+	f, err := getFileHandler(ctx, username, fileName)
+	if err != nil {
+		return err
+	}
+
+	// buffered so there is always a write, even if there are no records left
+	var b bytes.Buffer
+	err = csv.NewWriter(&b).WriteAll(records)
+	if err == nil {
+		_, err = f.Write(b.Bytes())
+	}
+	if err != nil {
+		safeClose(ctx, f, "write records")
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	return f.Close()
+}
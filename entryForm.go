@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultEffort = 0.5
+
+// entryForm is the add entry modal's fields, kept as entered so they can be shown again with any errors
+type entryForm struct {
+	Date        string  `form:"date"`
+	Duration    string  `form:"duration"`
+	Effort      float32 `form:"effort"`
+	Type        string  `form:"type"`
+	Description string  `form:"description"`
+
+	Errors map[string]string // field name to message
+}
+
+func newEntryForm(date string) entryForm {
+	return entryForm{
+		Date:   date,
+		Effort: defaultEffort,
+	}
+}
+
+func (f entryForm) EffortStr() string {
+	return fmt.Sprintf("%.2f", f.Effort)
+}
+
+// parse validates the form, setting Errors and returning false if anything is wrong
+func (f *entryForm) parse() (time.Time, DayEntry, bool) {
+	f.Errors = make(map[string]string)
+
+	date, err := time.Parse(time.DateOnly, strings.TrimSpace(f.Date))
+	if err != nil {
+		f.Errors["date"] = fmt.Sprintf("date must look like %s", time.DateOnly)
+	}
+
+	duration, err := parseFlexibleDuration(f.Duration)
+	if err != nil {
+		f.Errors["duration"] = err.Error()
+	}
+
+	if f.Effort < 0 || f.Effort > 1 {
+		f.Errors["effort"] = "effort must be between 0 and 1"
+	}
+
+	entry := DayEntry{
+		Duration:    duration,
+		Effort:      f.Effort,
+		Type:        normalizeActivityType(f.Type),
+		Description: strings.TrimSpace(f.Description),
+	}
+	if start, ok := parseStartTime(f.Duration); ok && !date.IsZero() {
+		entry.StartTime = date.Add(start)
+	}
+	return date, entry, len(f.Errors) == 0
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errorKind decides the status, and whether the message is shown as is
+type errorKind int
+
+const (
+	errValidation errorKind = iota
+	errNotFound
+	errUnauthorized
+	errForbidden
+	errStorageUnavailable
+)
+
+func (k errorKind) status() int {
+	switch k {
+	case errValidation:
+		return http.StatusBadRequest
+	case errNotFound:
+		return http.StatusNotFound
+	case errUnauthorized:
+		return http.StatusUnauthorized
+	case errForbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// appError is an error with a message meant for the user, anything it wraps is only logged
+type appError struct {
+	Kind    errorKind
+	Message string
+	Err     error
+}
+
+func (e *appError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *appError) Unwrap() error {
+	return e.Err
+}
+
+func validationError(format string, args ...any) error {
+	return &appError{Kind: errValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFoundError(message string) error {
+	return &appError{Kind: errNotFound, Message: message}
+}
+
+func unauthorizedError(message string) error {
+	return &appError{Kind: errUnauthorized, Message: message}
+}
+
+func forbiddenError(message string) error {
+	return &appError{Kind: errForbidden, Message: message}
+}
+
+// storageUnavailable marks a failed storage call, files that don't exist aren't failures and are returned as is
+func storageUnavailable(err error) error {
+	if err == nil || isNotExist(err) {
+		return err
+	}
+	return &appError{Kind: errStorageUnavailable, Message: "storage is unavailable, try again in a bit", Err: err}
+}
+
+// errorResponse is the status and message to show for any error a handler returns
+func errorResponse(err error) (int, string) {
+	var appErr *appError
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &appErr):
+		return appErr.Kind.status(), appErr.Message
+	case errors.As(err, &httpErr):
+		// from echo itself, e.g. no route or a bad form
+		if msg, ok := httpErr.Message.(string); ok {
+			return httpErr.Code, msg
+		}
+		return httpErr.Code, http.StatusText(httpErr.Code)
+	case isNotExist(err):
+		return http.StatusNotFound, "not found"
+	default:
+		return http.StatusInternalServerError, "something went wrong"
+	}
+}
+
+// isHtmxRequest is true for requests from hx- attributes, which get fragments back
+func isHtmxRequest(c echo.Context) bool {
+	return c.Request().Header.Get("HX-Request") == "true"
+}
+
+func wantsJSON(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON)
+}
+
+// httpErrorHandler shows errors as a page, a fragment for htmx to put in #errors, or JSON for API clients
+func httpErrorHandler(err error, c echo.Context) {
+	log := loggerFrom(c.Request().Context())
+	if c.Response().Committed {
+		log.Warn("error after the response started", "err", err)
+		return
+	}
+
+	status, msg := errorResponse(err)
+	if status >= http.StatusInternalServerError {
+		log.Error("request failed", "status", status, "err", err)
+	}
+
+	switch {
+	case c.Request().Method == http.MethodHead:
+		err = c.NoContent(status)
+	case wantsJSON(c):
+		err = c.JSON(status, map[string]any{"error": msg, "status": status})
+	case isHtmxRequest(c):
+		c.Response().Header().Set("HX-Retarget", "#errors")
+		c.Response().Header().Set("HX-Reswap", "innerHTML")
+		err = renderStatus(c, status, errorFragment(msg))
+	default:
+		err = renderStatus(c, status, page(errorPage(status, msg)))
+	}
+	if err != nil && !c.Response().Committed {
+		log.Error("failed rendering error response", "err", err)
+		err = c.String(status, msg)
+	}
+	if err != nil {
+		log.Error("failed writing error response", "err", err)
+	}
+}
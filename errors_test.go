@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_httpErrorHandler(t *testing.T) {
+	cfg := Config{JWTSecret: "test-secret"}
+	storage := LocalStorage{Dir: t.TempDir()}
+	err := createUser(context.Background(), storage.Open, UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _, err := issueJWT([]byte(cfg.JWTSecret), UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file where the directory should be, so every read fails
+	broken := filepath.Join(t.TempDir(), "data")
+	err = os.WriteFile(broken, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		storage    Storage
+		method     string
+		path       string
+		form       string
+		headers    map[string]string
+		wantStatus int
+		wantBody   string
+		wantHeader map[string]string
+	}{
+		{
+			name:       "page",
+			method:     http.MethodGet,
+			path:       "/nowhere",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "<h1>Not Found</h1>",
+		},
+		{
+			name:       "htmx fragment",
+			method:     http.MethodPost,
+			path:       "/scoring-model",
+			form:       "model=made-up",
+			headers:    map[string]string{"HX-Request": "true", "Content-Type": "application/x-www-form-urlencoded"},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `<div class="error-message"><span>unknown scoring model &#34;made-up&#34;</span>`,
+			wantHeader: map[string]string{"HX-Retarget": "#errors", "HX-Reswap": "innerHTML"},
+		},
+		{
+			name:       "json",
+			method:     http.MethodGet,
+			path:       "/admin/fsck",
+			headers:    map[string]string{"Accept": "application/json"},
+			wantStatus: http.StatusForbidden,
+			wantBody:   `{"error":"only admins can do that","status":403}`,
+		},
+		{
+			name:       "storage unavailable",
+			storage:    LocalStorage{Dir: broken},
+			method:     http.MethodGet,
+			path:       "/",
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "storage is unavailable, try again in a bit",
+		},
+		{
+			name:       "htmx without a session",
+			method:     http.MethodGet,
+			path:       "/feed-modal",
+			headers:    map[string]string{"HX-Request": "true", "Cookie": "session=expired"},
+			wantStatus: http.StatusUnauthorized,
+			wantHeader: map[string]string{"HX-Redirect": "/login"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.storage
+			if s == nil {
+				s = storage
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.form))
+			req.AddCookie(&http.Cookie{Name: "session", Value: session})
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			newServer(s, cfg).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.wantBody)
+			}
+			for k, v := range tt.wantHeader {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("header %s = %q, want %q", k, got, v)
+				}
+			}
+			if tt.headers["Accept"] == "application/json" && !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("body isn't JSON: %s", rec.Body)
+			}
+		})
+	}
+}
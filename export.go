@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+const (
+	exportFormatCSV  = "csv"
+	exportFormatJSON = "json"
+	exportFormatICS  = "ics"
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatCSV:  "text/csv",
+	exportFormatJSON: "application/json",
+	exportFormatICS:  "text/calendar",
+}
+
+// exportOptions are the format and the inclusive date range to export, zero dates are unbounded
+type exportOptions struct {
+	Format string
+	From   time.Time
+	To     time.Time
+}
+
+// exportProfile is UserInfo without anything secret
+type exportProfile struct {
+	Username         string    `json:"username"`
+	RestingHeartrate float64   `json:"restingHeartrate"`
+	DateOfBirth      time.Time `json:"dateOfBirth"`
+	ScoringModel     string    `json:"scoringModel,omitempty"`
+}
+
+type exportDocument struct {
+	Profile    exportProfile `json:"profile"`
+	ExportedAt time.Time     `json:"exportedAt"`
+	Days       []DayLog      `json:"days"`
+}
+
+// parseExportOptions validates the format and parses the optional from/to dates
+func parseExportOptions(format, from, to string) (exportOptions, error) {
+	o := exportOptions{Format: format}
+	if o.Format == "" {
+		o.Format = exportFormatCSV
+	}
+	if _, ok := exportContentTypes[o.Format]; !ok {
+		return o, fmt.Errorf("unknown export format %q", format)
+	}
+
+	var err error
+	if from != "" {
+		o.From, err = time.Parse(time.DateOnly, from)
+		if err != nil {
+			return o, fmt.Errorf("from must look like %s", time.DateOnly)
+		}
+	}
+	if to != "" {
+		o.To, err = time.Parse(time.DateOnly, to)
+		if err != nil {
+			return o, fmt.Errorf("to must look like %s", time.DateOnly)
+		}
+	}
+	if !o.From.IsZero() && !o.To.IsZero() && o.To.Before(o.From) {
+		return o, fmt.Errorf("to must not be before from")
+	}
+	return o, nil
+}
+
+func (o exportOptions) ContentType() string {
+	return exportContentTypes[o.Format]
+}
+
+func (o exportOptions) FileName(username string) string {
+	return fmt.Sprintf("activity-tracker-%s.%s", username, o.Format)
+}
+
+// exportUserData reads the user's data and writes it to w in the requested format
+func exportUserData(ctx context.Context, getFileHandler fileHandlerFunc, username string, o exportOptions, w io.Writer) error {
+	userInfo, err := readUserInfo(ctx, getFileHandler, username)
+	if err != nil {
+		return err
+	}
+
+	days, err := readDaysBetween(ctx, getFileHandler, username, o.From, o.To)
+	if err != nil {
+		return err
+	}
+
+	return writeExport(w, o, userInfo, filterDays(days, o.From, o.To), time.Now())
+}
+
+// filterDays returns the days within from and to (inclusive), oldest first
+func filterDays(days []DayLog, from, to time.Time) []DayLog {
+	var filtered []DayLog
+	for _, d := range days {
+		if !from.IsZero() && d.Date.Before(from) {
+			continue
+		}
+		if !to.IsZero() && d.Date.After(to) {
+			continue
+		}
+		filtered = append(filtered, d)
+	}
+	slices.SortStableFunc(filtered, func(a, b DayLog) int {
+		return a.Date.Compare(b.Date)
+	})
+	return filtered
+}
+
+func writeExport(w io.Writer, o exportOptions, userInfo UserInfo, days []DayLog, now time.Time) error {
+	switch o.Format {
+	case exportFormatJSON:
+		if days == nil {
+			days = []DayLog{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exportDocument{
+			Profile: exportProfile{
+				Username:         userInfo.Username,
+				RestingHeartrate: userInfo.RestingHeartrate,
+				DateOfBirth:      userInfo.DateOfBirth,
+				ScoringModel:     userInfo.ScoringModel,
+			},
+			ExportedAt: now.UTC(),
+			Days:       days,
+		})
+	case exportFormatICS:
+		iw := newICalWriter(w, "Activity Tracker", now)
+		for _, d := range days {
+			for i, e := range d.Entries {
+				iw.Event(entryEvent(userInfo.Username, d, i, e))
+			}
+		}
+		return iw.Close()
+	default:
+		return csv.NewWriter(w).WriteAll(toCSVRecords(days))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_writeExport(t *testing.T) {
+	userInfo := UserInfo{Username: "bob", Password: "secret-hash", DateOfBirth: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC)}
+	now := time.Date(2024, 6, 17, 11, 4, 0, 0, time.UTC)
+	days := filterDays([]DayLog{
+		{Date: time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: time.Hour, Effort: 0.8, Type: "run", StartTime: time.Date(2024, 6, 17, 7, 30, 0, 0, time.UTC)}}},
+		{Date: time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: 30 * time.Minute, Effort: 0.4, Description: "car cleaning, inside"}}},
+		{Date: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: time.Minute, Effort: 0.1, Description: "filtered"}}},
+	}, time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC), time.Time{})
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{
+			format: exportFormatCSV,
+			want: []string{
+				"2024-06-16,30m0s,0.40,\"car cleaning, inside\",,\n2024-06-17,1h0m0s,0.80,,run,07:30\n",
+			},
+		},
+		{
+			format: exportFormatJSON,
+			want:   []string{`"username": "bob"`, `"type": "run"`, `"exportedAt": "2024-06-17T11:04:00Z"`, `"startTime": "2024-06-17T07:30:00Z"`},
+		},
+		{
+			format: exportFormatICS,
+			want: []string{
+				"BEGIN:VCALENDAR\r\n",
+				"UID:20240616-0-bob@activity-tracker\r\nDTSTAMP:20240617T110400Z\r\nDTSTART;VALUE=DATE:20240616\r\nDTEND;VALUE=DATE:20240617\r\n",
+				"SUMMARY:car cleaning\\, inside (30m)\r\n",
+				"SUMMARY:run (1h)\r\n",
+				"END:VCALENDAR\r\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b bytes.Buffer
+			err := writeExport(&b, exportOptions{Format: tt.format}, userInfo, days, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := b.String()
+			for _, w := range tt.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("writeExport() = %q, missing %q", got, w)
+				}
+			}
+			if strings.Contains(got, "filtered") || strings.Contains(got, "secret-hash") {
+				t.Errorf("writeExport() = %q, contains filtered data", got)
+			}
+			if strings.Contains(got, "0001-01-01") {
+				t.Errorf("writeExport() = %q, has a zero start time", got)
+			}
+		})
+	}
+}
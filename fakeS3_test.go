@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an in-memory, path style S3 with the calls S3Storage makes
+type fakeS3 struct {
+	*httptest.Server
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	gets    int // object GETs that sent the body back
+}
+
+func newFakeS3(t testing.TB, bucket string) *fakeS3 {
+	f := &fakeS3{bucket: bucket, objects: make(map[string][]byte)}
+	f.Server = httptest.NewServer(f)
+	t.Cleanup(f.Close)
+
+	// static credentials, and nothing from the machine running the tests
+	t.Setenv("AWS_ACCESS_KEY_ID", "fake")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "fake")
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	return f
+}
+
+// storage is an S3Storage against the fake, the same as a MinIO config would be
+func (f *fakeS3) storage(prefix string) S3Storage {
+	return storageConfig{Bucket: f.bucket, Endpoint: f.URL, Region: "us-east-1", UsePathStyle: true}.s3Storage(prefix)
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+	case r.Method == http.MethodPost && key == "" && r.URL.Query().Has("delete"):
+		f.deleteObjects(w, r)
+	case r.Method == http.MethodGet && key != "":
+		body, ok := f.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		etag := etagOf(body)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		f.gets++
+		_, _ = w.Write(body)
+	case r.Method == http.MethodPut && key != "":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = body
+		w.Header().Set("ETag", etagOf(body))
+	case r.Method == http.MethodDelete && key != "":
+		// S3 doesn't mind if it wasn't there
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, delimiter string) {
+	type object struct {
+		Key  string
+		Size int
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		KeyCount       int
+		IsTruncated    bool
+		Contents       []object
+		CommonPrefixes []commonPrefix
+	}{Name: f.bucket, Prefix: prefix}
+
+	seen := make(map[string]bool)
+	for key, body := range f.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			p := prefix + rest[:i+len(delimiter)]
+			if !seen[p] {
+				seen[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{p})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, object{key, len(body)})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	writeXML(w, http.StatusOK, result)
+}
+
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	err := xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	type deleted struct {
+		Key string
+	}
+	result := struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		Deleted []deleted `xml:"Deleted"`
+	}{}
+	for _, o := range req.Objects {
+		delete(f.objects, o.Key)
+		result.Deleted = append(result.Deleted, deleted{o.Key})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// downloads is how many times an object's body has been sent
+func (f *fakeS3) downloads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.gets
+}
+
+// etagOf is the quoted md5, as S3 has for objects that weren't multipart uploads
+func etagOf(body []byte) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(body)))
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	writeXML(w, status, struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: code})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(v)
+}
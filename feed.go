@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+const secretTokenBytes = 32
+
+// newSecretToken returns an unguessable token for urls that work without a session, like the calendar feed
+func newSecretToken() (string, error) {
+	b := make([]byte, secretTokenBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validSecretToken compares in constant time, an empty token is never valid
+func validSecretToken(want, got string) bool {
+	if want == "" || got == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
+}
+
+// validFeedToken is false once the feed is revoked
+func validFeedToken(userInfo UserInfo, token string) bool {
+	return validSecretToken(userInfo.FeedToken, token)
+}
+
+// feedURL is the webcal:// address calendar apps subscribe to, empty if there is no feed
+func feedURL(host string, userInfo UserInfo) string {
+	if userInfo.FeedToken == "" {
+		return ""
+	}
+	return fmt.Sprintf("webcal://%s/feed/%s/%s.ics", host, url.PathEscape(userInfo.Username), userInfo.FeedToken)
+}
+
+// writeFeed writes every entry plus a summary event for each week (starting Monday)
+func writeFeed(w io.Writer, model ScoringModel, userInfo UserInfo, days []DayLog, now time.Time) error {
+	iw := newICalWriter(w, "Activity Tracker - "+userInfo.Username, now)
+	for _, d := range days {
+		for i, e := range d.Entries {
+			iw.Event(entryEvent(userInfo.Username, d, i, e))
+		}
+	}
+	for _, ev := range weeklySummaryEvents(model, newJWTClaims(userInfo, now), days, now) {
+		iw.Event(ev)
+	}
+	return iw.Close()
+}
+
+// weeklySummaryEvents is a week-long all-day event for each week since the first entry
+func weeklySummaryEvents(model ScoringModel, claims JWTClaims, days []DayLog, now time.Time) []icalEvent {
+	if len(days) == 0 {
+		return nil
+	}
+
+	byDate := daysByDate(days)
+	earliest := days[0].Date
+	for _, d := range days {
+		if d.Date.Before(earliest) {
+			earliest = d.Date
+		}
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var events []icalEvent
+	for monday := startOfWeek(earliest); !monday.After(today); monday = monday.AddDate(0, 0, 7) {
+		s := model.Summarize(claims, weekDays(byDate, monday))
+		events = append(events, icalEvent{
+			UID:     fmt.Sprintf("week-%s-%s@activity-tracker", monday.Format(icalDateFormat), claims.User),
+			Summary: fmt.Sprintf("Weekly score: %s", scoreStr(s.ComboScore)),
+			Description: fmt.Sprintf("low: %s / %s\nmoderate: %s / %s\nhigh: %s / %s",
+				scoreStr(s.LowIntensityScore), sumStr(s.LowIntensitySum),
+				scoreStr(s.ModerateIntensityScore), sumStr(s.ModerateIntensitySum),
+				scoreStr(s.HighIntensityScore), sumStr(s.HighIntensitySum),
+			),
+			Date: monday,
+			Days: 7,
+		})
+	}
+	return events
+}
+
+func daysByDate(days []DayLog) map[string]DayLog {
+	byDate := make(map[string]DayLog, len(days))
+	for _, d := range days {
+		byDate[d.Date.Format(time.DateOnly)] = d
+	}
+	return byDate
+}
+
+// weekDays is the seven days starting monday, filled in where there are no entries
+func weekDays(byDate map[string]DayLog, monday time.Time) []DayLog {
+	week := make([]DayLog, 7)
+	for i := range week {
+		date := monday.AddDate(0, 0, i)
+		week[i] = byDate[date.Format(time.DateOnly)]
+		week[i].Date = date
+	}
+	return week
+}
+
+func startOfWeek(date time.Time) time.Time {
+	offset := (int(date.Weekday()) + 6) % 7 // monday is 0
+	return date.AddDate(0, 0, -offset)
+}
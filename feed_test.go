@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_validFeedToken(t *testing.T) {
+	userInfo := UserInfo{FeedToken: "abc123"}
+	if !validFeedToken(userInfo, "abc123") {
+		t.Errorf("matching token should be valid")
+	}
+	if validFeedToken(userInfo, "abc124") {
+		t.Errorf("different token should be invalid")
+	}
+	if validFeedToken(UserInfo{}, "") {
+		t.Errorf("revoked feed should be invalid")
+	}
+}
+
+func Test_weeklySummaryEvents(t *testing.T) {
+	now := time.Date(2024, 6, 17, 11, 4, 0, 0, time.UTC) // a monday
+	days := []DayLog{
+		{Date: time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: 75 * time.Minute, Effort: 0.6}}},
+		{Date: time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: 150 * time.Minute, Effort: 0.6}}},
+	}
+
+	events := weeklySummaryEvents(effortScoringModel{}, JWTClaims{User: "bob"}, days, now)
+
+	want := []struct {
+		monday  time.Time
+		summary string
+	}{
+		{monday: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC), summary: "Weekly score: 100%"},
+		{monday: time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC), summary: "Weekly score: 50%"},
+		{monday: time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC), summary: "Weekly score: 0%"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		if !events[i].Date.Equal(w.monday) || events[i].Summary != w.summary || events[i].Days != 7 {
+			t.Errorf("event %d = %s %s, want %s %s", i, events[i].Date, events[i].Summary, w.monday, w.summary)
+		}
+	}
+}
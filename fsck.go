@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rows fsck can't fix are moved here, with when and why, rather than deleted
+const quarantineFileName = "activity-tracker-quarantine.csv"
+
+// fsckAction is what to do about an issue when repairing
+type fsckAction int
+
+const (
+	fsckLeave fsckAction = iota
+	fsckFix
+	fsckQuarantine
+	fsckDrop // only when asked, see autoRepair
+)
+
+// fsckIssue is everything wrong with one row of a data file
+type fsckIssue struct {
+	Row      int      `json:"row"` // 1-based, not counting the header
+	Record   []string `json:"record"`
+	Problems []string `json:"problems"`
+	Warnings []string `json:"warnings,omitempty"` // worth a look but not wrong, e.g. a planned workout's future date
+	Fix      []string `json:"fix,omitempty"`      // the repaired row, nil if it can't be repaired
+	Drop     bool     `json:"drop,omitempty"`     // the row can be removed, e.g. a duplicate, which might be two identical walks
+}
+
+func (i fsckIssue) Fixable() bool {
+	return i.Fix != nil
+}
+
+// Warning is an issue with nothing wrong, repairs leave it alone
+func (i fsckIssue) Warning() bool {
+	return len(i.Problems) == 0
+}
+
+type fsckReport struct {
+	Username    string      `json:"username"`
+	Rows        int         `json:"rows"`
+	Issues      []fsckIssue `json:"issues"`
+	Fixed       int         `json:"fixed"`
+	Quarantined int         `json:"quarantined"`
+}
+
+// autoRepair fixes what it can and quarantines the rest, duplicates are left as only a person can tell if they're real
+func autoRepair(issue fsckIssue) fsckAction {
+	switch {
+	case issue.Fixable():
+		return fsckFix
+	case issue.Drop:
+		return fsckLeave
+	}
+	return fsckQuarantine
+}
+
+// fsckAllUsers checks every user, see fsckUser
+func fsckAllUsers(ctx context.Context, storage Storage, now time.Time, decide func(string, fsckIssue) fsckAction) ([]fsckReport, error) {
+	users, err := storage.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []fsckReport
+	for _, u := range users {
+		var userDecide func(fsckIssue) fsckAction
+		if decide != nil {
+			userDecide = func(issue fsckIssue) fsckAction { return decide(u, issue) }
+		}
+		r, err := fsckUser(ctx, storage.Open, u, now, userDecide)
+		if err != nil {
+			return reports, fmt.Errorf("checking %s: %w", u, err)
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// fsckUser checks a user's data file, if decide is given the issues are also repaired
+func fsckUser(ctx context.Context, getFileHandler fileHandlerFunc, username string, now time.Time, decide func(fsckIssue) fsckAction) (fsckReport, error) {
+	records, err := readDataRecords(ctx, getFileHandler, username)
+	segmented := errors.Is(err, errSegmentedLayout)
+	if segmented {
+		records, err = readSegmented(ctx, getFileHandler, username, time.Time{}, time.Time{})
+	}
+	if err != nil {
+		return fsckReport{}, err
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	report := fsckReport{
+		Username: username,
+		Rows:     len(records),
+		Issues:   checkRecords(records, today),
+	}
+	if decide == nil || len(report.Issues) == 0 {
+		return report, nil
+	}
+
+	kept, quarantined, fixed := repairRecords(records, report.Issues, decide)
+	if fixed == 0 && len(quarantined) == 0 {
+		return report, nil
+	}
+
+	// quarantine first, so a failed write can't lose anything
+	if len(quarantined) > 0 {
+		err = appendQuarantine(ctx, getFileHandler, username, quarantined, now)
+		if err != nil {
+			return report, err
+		}
+	}
+	if segmented {
+		err = replaceSegmented(ctx, getFileHandler, username, records, kept)
+	} else {
+		err = writeDataRecords(ctx, getFileHandler, username, kept)
+	}
+	if err != nil {
+		return report, err
+	}
+	report.Fixed = fixed
+	report.Quarantined = len(quarantined)
+	return report, nil
+}
+
+// checkRecords finds the problems in a data file's rows, anything after today is in the future
+func checkRecords(records [][]string, today time.Time) []fsckIssue {
+	var issues []fsckIssue
+	firstRow := make(map[string]int)
+	for i, r := range records {
+		row := i + 1
+		problems, warnings, fix := checkRecord(r, today)
+		if len(problems) > 0 || len(warnings) > 0 {
+			issue := fsckIssue{Row: row, Record: r, Problems: problems, Warnings: warnings}
+			if len(problems) > 0 {
+				issue.Fix = fix
+			}
+			issues = append(issues, issue)
+		}
+		if fix == nil {
+			continue
+		}
+
+		// compared once repaired, so 0.8 and 0.80 are the same
+		key := strings.Join(fix, ",")
+		first, ok := firstRow[key]
+		if !ok {
+			firstRow[key] = row
+			continue
+		}
+		if len(problems) == 0 && len(warnings) == 0 {
+			issues = append(issues, fsckIssue{Row: row, Record: r})
+		}
+		issue := &issues[len(issues)-1]
+		issue.Problems = append(issue.Problems, fmt.Sprintf("duplicate of row %d", first))
+		issue.Drop = true
+	}
+	return issues
+}
+
+// checkRecord returns what's wrong with a row, what only looks wrong, and the row in toCSVRecords form, nil if it can't be repaired
+func checkRecord(r []string, today time.Time) ([]string, []string, []string) {
+	if len(r) < 4 || len(r) > 6 {
+		return []string{fmt.Sprintf("has %d columns, expected 4 to 6", len(r))}, nil, nil
+	}
+
+	var problems, warnings []string
+	fixed := slices.Clone(r)
+	repairable := true
+
+	date, err := time.Parse(time.DateOnly, r[0])
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("bad date %q", r[0]))
+		repairable = false
+	} else if date.After(today) {
+		// the entry form allows them, for planned workouts
+		warnings = append(warnings, fmt.Sprintf("date %s is in the future", r[0]))
+	}
+
+	duration, err := time.ParseDuration(r[1])
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("bad duration %q", r[1]))
+		// probably written by hand
+		duration, err = parseFlexibleDuration(r[1])
+		if err != nil {
+			repairable = false
+		}
+		fixed[1] = duration.String()
+	}
+	if duration < 0 {
+		problems = append(problems, fmt.Sprintf("negative duration %s", r[1]))
+		fixed[1] = (-duration).String()
+	}
+
+	effort, err := strconv.ParseFloat(r[2], 32)
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("bad effort %q", r[2]))
+		repairable = false
+	case effort > 1 && effort <= 100:
+		// a percentage
+		problems = append(problems, fmt.Sprintf("effort %s is outside 0-1", r[2]))
+		fixed[2] = strconv.FormatFloat(effort/100, 'f', 2, 32)
+	case effort < 0 || effort > 1:
+		problems = append(problems, fmt.Sprintf("effort %s is outside 0-1", r[2]))
+		repairable = false
+	}
+
+	if len(r) > 5 && r[5] != "" {
+		_, err = time.Parse("15:04", r[5])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("bad start time %q", r[5]))
+			fixed[5] = ""
+		}
+	}
+
+	if !repairable {
+		return problems, warnings, nil
+	}
+	date, entry, err := parseCSVRecord(fixed)
+	if err != nil {
+		return append(problems, err.Error()), warnings, nil
+	}
+	return problems, warnings, toCSVRecords([]DayLog{{Date: date, Entries: []DayEntry{entry}}})[0]
+}
+
+// repairRecords applies the chosen action to each issue, returning the rows to keep and the issues to quarantine
+func repairRecords(records [][]string, issues []fsckIssue, decide func(fsckIssue) fsckAction) ([][]string, []fsckIssue, int) {
+	actions := make(map[int]fsckAction, len(issues))
+	byRow := make(map[int]fsckIssue, len(issues))
+	for _, issue := range issues {
+		if issue.Warning() {
+			continue
+		}
+		action := decide(issue)
+		if action == fsckFix && !issue.Fixable() || action == fsckDrop && !issue.Drop {
+			action = fsckLeave
+		}
+		actions[issue.Row] = action
+		byRow[issue.Row] = issue
+	}
+
+	var kept [][]string
+	var quarantined []fsckIssue
+	fixed := 0
+	for i, r := range records {
+		issue := byRow[i+1]
+		switch actions[i+1] {
+		case fsckFix:
+			fixed++
+			kept = append(kept, issue.Fix)
+		case fsckDrop:
+			fixed++
+		case fsckQuarantine:
+			quarantined = append(quarantined, issue)
+		default:
+			kept = append(kept, r)
+		}
+	}
+	return kept, quarantined, fixed
+}
+
+// appendQuarantine adds the rows to the user's quarantine file, prefixed with when, the row, and the problems
+func appendQuarantine(ctx context.Context, getFileHandler fileHandlerFunc, username string, issues []fsckIssue, now time.Time) error {
+	records, err := readRecords(ctx, getFileHandler, username, quarantineFileName)
+	if err != nil && !isNotExist(err) {
+		return err
+	}
+	for _, issue := range issues {
+		r := []string{now.UTC().Format(time.RFC3339), strconv.Itoa(issue.Row), strings.Join(issue.Problems, "; ")}
+		records = append(records, append(r, issue.Record...))
+	}
+	return writeRecords(ctx, getFileHandler, username, quarantineFileName, records)
+}
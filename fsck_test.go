@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_checkRecords(t *testing.T) {
+	today := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	records := [][]string{
+		{"2024-06-17", "45m0s", "0.80", "morning", "run", "07:00"},
+		{"2024-06-16", "30m0s", "0.5", "walk"},
+		{"2024-06-16", "30m0s", "0.50", "walk", "", ""},
+		{"2024-06-15", "1:15", "80", "bike"},
+		{"2024-06-15", "-20m", "0.3", "yoga", "", "7am"},
+		{"2024-06-18", "20m", "0.3", "tomorrow"},
+		{"june 14", "20m", "0.3", "bad date"},
+		{"2024-06-14", "soon", "0.3", "bad duration"},
+		{"2024-06-14", "20m", "-1", "bad effort"},
+		{"2024-06-14", "20m"},
+	}
+
+	want := []fsckIssue{
+		{Row: 3, Record: records[2], Problems: []string{"duplicate of row 2"}, Drop: true},
+		{Row: 4, Record: records[3], Problems: []string{`bad duration "1:15"`, "effort 80 is outside 0-1"}, Fix: []string{"2024-06-15", "1h15m0s", "0.80", "bike", "", ""}},
+		{Row: 5, Record: records[4], Problems: []string{"negative duration -20m", `bad start time "7am"`}, Fix: []string{"2024-06-15", "20m0s", "0.30", "yoga", "", ""}},
+		{Row: 6, Record: records[5], Warnings: []string{"date 2024-06-18 is in the future"}},
+		{Row: 7, Record: records[6], Problems: []string{`bad date "june 14"`}},
+		{Row: 8, Record: records[7], Problems: []string{`bad duration "soon"`}},
+		{Row: 9, Record: records[8], Problems: []string{"effort -1 is outside 0-1"}},
+		{Row: 10, Record: records[9], Problems: []string{"has 2 columns, expected 4 to 6"}},
+	}
+	got := checkRecords(records, today)
+	if len(got) != len(want) {
+		t.Fatalf("checkRecords() = %+v, want %d issues", got, len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("issue %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func Test_fsckUser(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	now := time.Date(2024, 6, 17, 12, 0, 0, 0, time.UTC)
+
+	records := [][]string{
+		{"2024-06-16", "30m0s", "0.50", "walk", "", ""},
+		{"2024-06-16", "30m0s", "0.50", "walk", "", ""},
+		{"2024-06-15", "1:15", "0.8", "bike"},
+		{"2024-06-14", "20m", "-1", "bad effort"},
+		{"2024-06-20", "45m0s", "0.60", "planned run", "", ""},
+	}
+	// a version 1 file, migrated on first access
+	err := writeRecords(ctx, storage.Open, "alice", userDataFileName, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checking doesn't change anything
+	r, err := fsckUser(ctx, storage.Open, "alice", now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rows != 5 || len(r.Issues) != 4 || r.Fixed != 0 || r.Quarantined != 0 {
+		t.Errorf("check report = %+v", r)
+	}
+
+	// the duplicate might be two walks, and the future date a planned run, so they're left
+	r, err = fsckUser(ctx, storage.Open, "alice", now, autoRepair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Fixed != 1 || r.Quarantined != 1 {
+		t.Errorf("repair report = %+v", r)
+	}
+	// unless someone says it's a duplicate
+	r, err = fsckUser(ctx, storage.Open, "alice", now, func(issue fsckIssue) fsckAction {
+		if issue.Drop {
+			return fsckDrop
+		}
+		return fsckLeave
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Fixed != 1 || r.Quarantined != 0 {
+		t.Errorf("removing the duplicate report = %+v", r)
+	}
+
+	got, err := readDataRecords(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"2024-06-16", "30m0s", "0.50", "walk", "", ""},
+		{"2024-06-15", "1h15m0s", "0.80", "bike", "", ""},
+		{"2024-06-20", "45m0s", "0.60", "planned run", "", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("repaired data = %v, want %v", got, want)
+	}
+
+	quarantine, err := readRecords(ctx, storage.Open, "alice", quarantineFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQuarantine := [][]string{{"2024-06-17T12:00:00Z", "4", "effort -1 is outside 0-1", "2024-06-14", "20m", "-1", "bad effort", "", ""}}
+	if !reflect.DeepEqual(quarantine, wantQuarantine) {
+		t.Errorf("quarantine = %v, want %v", quarantine, wantQuarantine)
+	}
+
+	r, err = fsckUser(ctx, storage.Open, "alice", now, nil)
+	if err != nil || len(r.Issues) != 1 || !r.Issues[0].Warning() {
+		t.Errorf("after repair = %+v, %v, want only the future date's warning", r, err)
+	}
+}
+
+// Test_editEntries_keepsBadRows is deleting and updating entries in a file with rows that don't parse, which are left for fsck
+func Test_editEntries_keepsBadRows(t *testing.T) {
+	ctx := context.Background()
+	june16 := time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC)
+	june17 := june16.AddDate(0, 0, 1)
+	bad := [][]string{
+		{"june 14", "20m", "0.3", "bad date"},
+		{"2024-06-17", "soon", "0.3", "bad duration"},
+	}
+
+	for _, layout := range []dataLayout{layoutFile, layoutSegmented} {
+		t.Run(string(layout), func(t *testing.T) {
+			storage := LocalStorage{Dir: t.TempDir()}
+			err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = writeDataRecords(ctx, storage.Open, "alice", [][]string{
+				{"2024-06-17", "45m0s", "0.80", "run", "", ""},
+				bad[0],
+				bad[1],
+				{"2024-06-17", "30m0s", "0.50", "walk", "", ""},
+				{"2024-06-16", "1h0m0s", "0.50", "bike", "", ""},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = setLayout(ctx, storage.Open, "alice", layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the bad duration on the 17th isn't one of its entries
+			removed, err := deleteEntry(ctx, storage.Open, "alice", june17, 1)
+			if err != nil || removed.Description != "walk" {
+				t.Fatalf("deleteEntry() = %+v, %v", removed, err)
+			}
+			err = updateEntry(ctx, storage.Open, "alice", june16, 0, june17, DayEntry{Duration: time.Hour, Effort: 0.6, Description: "swim"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			records, err := allDataRecords(ctx, storage.Open, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range bad {
+				if !slices.ContainsFunc(records, func(r []string) bool { return slices.Equal(r, b) }) {
+					t.Errorf("records = %v, lost %v", records, b)
+				}
+			}
+			days, err := readDays(ctx, storage.Open, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dayEntryCounts(days); got != "2024-06-17:2" {
+				t.Errorf("readDays() = %s", got)
+			}
+		})
+	}
+}
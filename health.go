@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// unauthenticated, for whatever is supervising the server
+const (
+	healthRoute  = "/healthz"
+	readyRoute   = "/readyz"
+	versionRoute = "/version"
+)
+
+// readyTimeout bounds the storage check, a probe shouldn't hang on a slow bucket
+const readyTimeout = 5 * time.Second
+
+// readyCheck is nil when the part of the server it checks is fine
+type readyCheck func(ctx context.Context) error
+
+func readyChecks(storage Storage, cfg Config) map[string]readyCheck {
+	return map[string]readyCheck{
+		"storage": func(ctx context.Context) error {
+			_, err := storage.ListUsers(ctx)
+			return err
+		},
+		"jwt_secret": func(ctx context.Context) error {
+			return cfg.requireJWTSecret()
+		},
+		"templates": func(ctx context.Context) error {
+			return page(loginForm()).Render(ctx, io.Discard)
+		},
+	}
+}
+
+// addHealthRoutes adds /healthz, /readyz and /version
+// readyz only says which checks failed, the errors are logged since anyone can ask
+func addHealthRoutes(e *echo.Echo, storage Storage, cfg Config) {
+	checks := readyChecks(storage, cfg)
+
+	e.GET(healthRoute, func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	e.GET(readyRoute, func(c echo.Context) error {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), readyTimeout)
+		defer cancel()
+
+		status := http.StatusOK
+		results := make(map[string]string, len(checks))
+		for name, check := range checks {
+			err := check(ctx)
+			if err != nil {
+				loggerFrom(ctx).Warn("readiness check failed", "check", name, "err", err)
+				results[name] = "failing"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			results[name] = "ok"
+		}
+		return c.JSON(status, map[string]any{"status": http.StatusText(status), "checks": results})
+	})
+
+	e.GET(versionRoute, func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{
+			"version": version,
+			"commit":  commit,
+			"date":    date,
+			"go":      runtime.Version(),
+		})
+	})
+}
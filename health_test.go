@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_healthRoutes(t *testing.T) {
+	broken := filepath.Join(t.TempDir(), "data")
+	err := os.WriteFile(broken, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		storage    Storage
+		secret     string
+		path       string
+		wantStatus int
+		want       map[string]any
+	}{
+		{
+			name:       "healthz",
+			storage:    LocalStorage{Dir: broken},
+			path:       healthRoute,
+			wantStatus: http.StatusOK,
+			want:       map[string]any{"status": "ok"},
+		},
+		{
+			name:       "ready",
+			storage:    LocalStorage{Dir: t.TempDir()},
+			secret:     "test-secret",
+			path:       readyRoute,
+			wantStatus: http.StatusOK,
+			want:       map[string]any{"status": "OK", "checks": map[string]any{"storage": "ok", "jwt_secret": "ok", "templates": "ok"}},
+		},
+		{
+			name:       "not ready",
+			storage:    LocalStorage{Dir: broken},
+			path:       readyRoute,
+			wantStatus: http.StatusServiceUnavailable,
+			want:       map[string]any{"status": "Service Unavailable", "checks": map[string]any{"storage": "failing", "jwt_secret": "failing", "templates": "ok"}},
+		},
+		{
+			name:       "version",
+			storage:    LocalStorage{Dir: t.TempDir()},
+			path:       versionRoute,
+			wantStatus: http.StatusOK,
+			want:       map[string]any{"version": version, "commit": commit, "date": date},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// without a session, these shouldn't redirect to /login
+			rec := httptest.NewRecorder()
+			newServer(tt.storage, Config{JWTSecret: tt.secret}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var got map[string]any
+			err := json.Unmarshal(rec.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatalf("body %s: %v", rec.Body, err)
+			}
+			for k, v := range tt.want {
+				if g, _ := json.Marshal(got[k]); string(g) != mustJSON(t, v) {
+					t.Errorf("%s = %s, want %s", k, g, mustJSON(t, v))
+				}
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc5545
+const icalDateFormat = "20060102"
+const icalDateTimeFormat = "20060102T150405Z"
+const icalFloatingDateTimeFormat = "20060102T150405" // local to whoever is looking at it
+
+type icalEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Date        time.Time // all-day event if Start is zero
+	Days        int       // length of an all-day event, defaults to one
+	Start       time.Time // floating, we don't know the user's time zone
+	End         time.Time
+}
+
+// icalWriter writes a VCALENDAR, call Close to finish it
+type icalWriter struct {
+	w     io.Writer
+	stamp time.Time
+	err   error
+}
+
+func newICalWriter(w io.Writer, name string, stamp time.Time) *icalWriter {
+	iw := &icalWriter{w: w, stamp: stamp.UTC()}
+	iw.line("BEGIN:VCALENDAR")
+	iw.line("VERSION:2.0")
+	iw.line("PRODID:-//jamethy//activity-tracker//EN")
+	iw.line("CALSCALE:GREGORIAN")
+	iw.line("X-WR-CALNAME:" + icalEscape(name))
+	return iw
+}
+
+func (iw *icalWriter) Event(e icalEvent) {
+	iw.line("BEGIN:VEVENT")
+	iw.line("UID:" + e.UID)
+	iw.line("DTSTAMP:" + iw.stamp.Format(icalDateTimeFormat))
+	if e.Start.IsZero() {
+		iw.line("DTSTART;VALUE=DATE:" + e.Date.Format(icalDateFormat))
+		iw.line("DTEND;VALUE=DATE:" + e.Date.AddDate(0, 0, max(1, e.Days)).Format(icalDateFormat))
+	} else {
+		iw.line("DTSTART:" + e.Start.Format(icalFloatingDateTimeFormat))
+		iw.line("DTEND:" + e.End.Format(icalFloatingDateTimeFormat))
+	}
+	iw.line("SUMMARY:" + icalEscape(e.Summary))
+	if e.Description != "" {
+		iw.line("DESCRIPTION:" + icalEscape(e.Description))
+	}
+	iw.line("TRANSP:TRANSPARENT")
+	iw.line("END:VEVENT")
+}
+
+func (iw *icalWriter) Close() error {
+	iw.line("END:VCALENDAR")
+	return iw.err
+}
+
+// line writes a content line, folded at 75 octets
+func (iw *icalWriter) line(l string) {
+	if iw.err != nil {
+		return
+	}
+	var b strings.Builder
+	limit := 75
+	for len(l) > limit {
+		cut := limit
+		// don't split a multibyte character
+		for cut > 0 && l[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(l[:cut])
+		b.WriteString("\r\n ")
+		l = l[cut:]
+		limit = 74 // the leading space counts
+	}
+	b.WriteString(l)
+	b.WriteString("\r\n")
+	_, iw.err = io.WriteString(iw.w, b.String())
+}
+
+func icalEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// entryEvent is the calendar event for a single entry, index is its position within the day
+func entryEvent(username string, d DayLog, index int, e DayEntry) icalEvent {
+	ev := icalEvent{
+		UID:         fmt.Sprintf("%s-%d-%s@activity-tracker", d.Date.Format(icalDateFormat), index, username),
+		Summary:     fmt.Sprintf("%s (%s)", e.Label(), sumStr(e.Duration)),
+		Description: fmt.Sprintf("duration: %s\neffort: %.0f%%", sumStr(e.Duration), 100*e.Effort),
+		Date:        d.Date,
+	}
+	if !e.StartTime.IsZero() {
+		ev.Start = e.StartTime
+		ev.End = e.StartTime.Add(e.Duration)
+	}
+	return ev
+}
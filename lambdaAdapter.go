@@ -2,32 +2,35 @@ package main
 
 import (
 	"context"
+	"log/slog"
+	"net/http"
+
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/awslabs/aws-lambda-go-api-proxy/core"
 	echoadapter "github.com/awslabs/aws-lambda-go-api-proxy/echo"
 	"github.com/labstack/echo/v4"
-	"log/slog"
-	"net/http"
 )
 
 // MyProxy needed to extend the awslabs proxy because echo+templ+proxy has some disagreement on handling flush
 // https://github.com/labstack/echo/issues/2016
+// it wraps whichever of the proxy's response writers the event needs
 type MyProxy struct {
-	*core.ProxyResponseWriterV2
+	http.ResponseWriter
 }
 
-func (m *MyProxy) Flush() {
-	slog.Info("in flush")
+func (m MyProxy) Flush() {
+	slog.Debug("in flush")
 }
 
-func (m *MyProxy) FlushError() error {
-	slog.Info("in flush error")
+func (m MyProxy) FlushError() error {
+	slog.Debug("in flush error")
 	return nil
 }
 
 // LambdaEchoProxy is a modified copy of aws-lambda-go-api-proxy that lets me grab the request ID
 // https://github.com/awslabs/aws-lambda-go-api-proxy/blob/581201ab7e19039735cc5b1cbef2e567ca2ed008/echo/adapterv2.go#L39
-// This plus requestIDMiddleware gets the request ID in all the logs
+// This plus RequestLogger gets the request ID in all the logs
 func LambdaEchoProxy(e *echo.Echo) func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	adapter := echoadapter.NewV2(e)
 	return func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
@@ -42,9 +45,10 @@ func LambdaEchoProxy(e *echo.Echo) func(ctx context.Context, req events.APIGatew
 		}
 		// add in request id header
 		httpReq.Header.Add(echo.HeaderXRequestID, req.RequestContext.RequestID)
+		addLambdaTraceHeader(ctx, httpReq)
 
-		respWriter := &MyProxy{core.NewProxyResponseWriterV2()}
-		adapter.Echo.ServeHTTP(http.ResponseWriter(respWriter), httpReq)
+		respWriter := core.NewProxyResponseWriterV2()
+		adapter.Echo.ServeHTTP(MyProxy{respWriter}, httpReq)
 
 		proxyResponse, err := respWriter.GetProxyResponse()
 		if err != nil {
@@ -74,3 +78,59 @@ func logLambdaRequest(req events.APIGatewayV2HTTPRequest, logFn func(events.APIG
 		req.Headers["authorization"] = authHeader
 	}
 }
+
+// LambdaEchoProxyV1 is LambdaEchoProxy for API Gateway v1 (REST API) events
+func LambdaEchoProxyV1(e *echo.Echo) func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	adapter := echoadapter.New(e)
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		slog.Info("Incoming API Gateway v1 Request", "method", req.HTTPMethod, "path", req.Path)
+
+		httpReq, err := adapter.EventToRequestWithContext(ctx, req)
+		if err != nil {
+			return core.GatewayTimeout(), core.NewLoggedError("Could not convert proxy event to request: %v", err)
+		}
+		httpReq.Header.Add(echo.HeaderXRequestID, req.RequestContext.RequestID)
+		addLambdaTraceHeader(ctx, httpReq)
+
+		respWriter := core.NewProxyResponseWriter()
+		adapter.Echo.ServeHTTP(MyProxy{respWriter}, httpReq)
+
+		proxyResponse, err := respWriter.GetProxyResponse()
+		if err != nil {
+			return core.GatewayTimeout(), core.NewLoggedError("Error while generating proxy response: %v", err)
+		}
+		if proxyResponse.StatusCode == http.StatusInternalServerError {
+			slog.Error("500 Status Request", "method", req.HTTPMethod, "path", req.Path)
+		}
+		return proxyResponse, nil
+	}
+}
+
+// LambdaEchoProxyALB is LambdaEchoProxy for ALB target group events, which don't have a request ID so the lambda's is used
+func LambdaEchoProxyALB(e *echo.Echo) func(ctx context.Context, req events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error) {
+	adapter := echoadapter.NewALB(e)
+	return func(ctx context.Context, req events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error) {
+		slog.Info("Incoming ALB Request", "method", req.HTTPMethod, "path", req.Path)
+
+		httpReq, err := adapter.EventToRequestWithContext(ctx, req)
+		if err != nil {
+			return core.GatewayTimeoutALB(), core.NewLoggedError("Could not convert proxy event to request: %v", err)
+		}
+		if lc, ok := lambdacontext.FromContext(ctx); ok {
+			httpReq.Header.Add(echo.HeaderXRequestID, lc.AwsRequestID)
+		}
+		addLambdaTraceHeader(ctx, httpReq)
+
+		respWriter := core.NewProxyResponseWriterALB()
+		adapter.Echo.ServeHTTP(MyProxy{respWriter}, httpReq)
+
+		proxyResponse, err := respWriter.GetProxyResponse()
+		if err != nil {
+			return core.GatewayTimeoutALB(), core.NewLoggedError("Error while generating proxy response: %v", err)
+		}
+		if proxyResponse.StatusCode == http.StatusInternalServerError {
+			slog.Error("500 Status Request", "method", req.HTTPMethod, "path", req.Path)
+		}
+		return proxyResponse, nil
+	}
+}
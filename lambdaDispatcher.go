@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/labstack/echo/v4"
+)
+
+// lambdaEventKind is what invoked the lambda, see lambdaEventKindOf
+type lambdaEventKind string
+
+const (
+	lambdaEventAPIGatewayV1 lambdaEventKind = "api-gateway-v1"
+	lambdaEventAPIGatewayV2 lambdaEventKind = "api-gateway-v2"
+	lambdaEventFunctionURL  lambdaEventKind = "function-url"
+	lambdaEventALB          lambdaEventKind = "alb"
+	lambdaEventSchedule     lambdaEventKind = "schedule"
+)
+
+// lambdaJob is a background job, run by an EventBridge schedule
+type lambdaJob func(ctx context.Context) error
+
+// lambdaEventProbe has just enough of every event type to tell them apart
+type lambdaEventProbe struct {
+	Source         string `json:"source"`
+	DetailType     string `json:"detail-type"`
+	HTTPMethod     string `json:"httpMethod"`
+	RequestContext struct {
+		ELB        json.RawMessage `json:"elb"`
+		HTTP       json.RawMessage `json:"http"`
+		DomainName string          `json:"domainName"`
+	} `json:"requestContext"`
+}
+
+func lambdaEventKindOf(payload json.RawMessage) (lambdaEventKind, error) {
+	var probe lambdaEventProbe
+	err := json.Unmarshal(payload, &probe)
+	if err != nil {
+		return "", fmt.Errorf("lambda event isn't a json object: %w", err)
+	}
+
+	switch {
+	case probe.Source == "aws.events" && probe.DetailType == "Scheduled Event":
+		return lambdaEventSchedule, nil
+	case probe.RequestContext.ELB != nil:
+		return lambdaEventALB, nil
+	case probe.RequestContext.HTTP != nil && strings.Contains(probe.RequestContext.DomainName, ".lambda-url."):
+		return lambdaEventFunctionURL, nil
+	case probe.RequestContext.HTTP != nil:
+		return lambdaEventAPIGatewayV2, nil
+	case probe.HTTPMethod != "":
+		return lambdaEventAPIGatewayV1, nil
+	}
+	return "", errors.New("unrecognized lambda event")
+}
+
+// LambdaDispatcher sends http events to echo, whichever service they came through, and scheduled events to jobs
+func LambdaDispatcher(e *echo.Echo, jobs map[string]lambdaJob) func(ctx context.Context, payload json.RawMessage) (any, error) {
+	proxyV1 := LambdaEchoProxyV1(e)
+	proxyV2 := LambdaEchoProxy(e)
+	proxyALB := LambdaEchoProxyALB(e)
+
+	return func(ctx context.Context, payload json.RawMessage) (any, error) {
+		kind, err := lambdaEventKindOf(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case lambdaEventAPIGatewayV1:
+			var req events.APIGatewayProxyRequest
+			err = json.Unmarshal(payload, &req)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s event: %w", kind, err)
+			}
+			return proxyV1(ctx, req)
+		case lambdaEventAPIGatewayV2, lambdaEventFunctionURL:
+			// function urls use the same payload as api gateway v2
+			var req events.APIGatewayV2HTTPRequest
+			err = json.Unmarshal(payload, &req)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s event: %w", kind, err)
+			}
+			return proxyV2(ctx, req)
+		case lambdaEventALB:
+			var req events.ALBTargetGroupRequest
+			err = json.Unmarshal(payload, &req)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s event: %w", kind, err)
+			}
+			return proxyALB(ctx, req)
+		default:
+			var event events.EventBridgeEvent
+			err = json.Unmarshal(payload, &event)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s event: %w", kind, err)
+			}
+			return nil, runScheduledJob(ctx, jobs, event)
+		}
+	}
+}
+
+// runScheduledJob runs the job named by {"job": name} in the detail, or by the end of the rule's name
+// e.g. the rule arn:aws:events:us-east-1:123456789012:rule/activity-tracker-backup runs backup
+func runScheduledJob(ctx context.Context, jobs map[string]lambdaJob, event events.EventBridgeEvent) error {
+	name := scheduledJobName(jobs, event)
+	job, ok := jobs[name]
+	if !ok {
+		return fmt.Errorf("no job for scheduled event from %v", event.Resources)
+	}
+
+	ctx = withLogAttrs(ctx, "job", name, "event", event.ID)
+	loggerFrom(ctx).Info("Running scheduled job")
+	ctx, span := tracer.Start(ctx, "job "+name)
+	err := job(ctx)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	return nil
+}
+
+func scheduledJobName(jobs map[string]lambdaJob, event events.EventBridgeEvent) string {
+	var detail struct {
+		Job string `json:"job"`
+	}
+	if json.Unmarshal(event.Detail, &detail) == nil && detail.Job != "" {
+		return detail.Job
+	}
+
+	for _, arn := range event.Resources {
+		_, rule, ok := strings.Cut(arn, ":rule/")
+		if !ok {
+			continue
+		}
+		for name := range jobs {
+			if rule == name || strings.HasSuffix(rule, "-"+name) {
+				return name
+			}
+		}
+	}
+	return ""
+}
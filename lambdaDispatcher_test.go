@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/labstack/echo/v4"
+)
+
+func Test_LambdaDispatcher(t *testing.T) {
+	e := echo.New()
+	e.GET("/login", func(c echo.Context) error {
+		return c.String(http.StatusOK, "login "+c.Request().Header.Get(echo.HeaderXRequestID))
+	})
+
+	var ran []string
+	dispatch := LambdaDispatcher(e, map[string]lambdaJob{
+		"backup": func(context.Context) error {
+			ran = append(ran, "backup")
+			return nil
+		},
+		"digest": func(context.Context) error {
+			ran = append(ran, "digest")
+			return nil
+		},
+	})
+
+	tests := []struct {
+		file     string
+		wantKind lambdaEventKind
+		want     any
+		wantErr  bool
+	}{
+		{
+			file:     "api-gateway-v1.json",
+			wantKind: lambdaEventAPIGatewayV1,
+			want:     events.APIGatewayProxyResponse{StatusCode: 200, Body: "login c6af9ac6-7b61-11e6-9a41-93e8deadbeef"},
+		},
+		{
+			file:     "api-gateway-v2.json",
+			wantKind: lambdaEventAPIGatewayV2,
+			want:     events.APIGatewayV2HTTPResponse{StatusCode: 200, Body: "login JKJaXmPLvHcESHA="},
+		},
+		{
+			file:     "function-url.json",
+			wantKind: lambdaEventFunctionURL,
+			want:     events.APIGatewayV2HTTPResponse{StatusCode: 200, Body: "login id"},
+		},
+		{
+			file:     "alb.json",
+			wantKind: lambdaEventALB,
+			want:     events.ALBTargetGroupResponse{StatusCode: 200, Body: "login "},
+		},
+		{
+			file:     "schedule-backup.json",
+			wantKind: lambdaEventSchedule,
+		},
+		{
+			file:     "schedule-unknown.json",
+			wantKind: lambdaEventSchedule,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", "lambda", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			kind, err := lambdaEventKindOf(payload)
+			if err != nil || kind != tt.wantKind {
+				t.Errorf("lambdaEventKindOf() = %v, %v, want %v", kind, err, tt.wantKind)
+			}
+
+			got, err := dispatch(context.Background(), payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dispatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Fatalf("dispatch() = %T, want %T", got, tt.want)
+			}
+			// headers vary between response types, compare what matters
+			status := reflect.ValueOf(got).FieldByName("StatusCode").Int()
+			body := reflect.ValueOf(got).FieldByName("Body").String()
+			wantV := reflect.ValueOf(tt.want)
+			if status != wantV.FieldByName("StatusCode").Int() || body != wantV.FieldByName("Body").String() {
+				t.Errorf("dispatch() = %d %q, want %v", status, body, tt.want)
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(ran, []string{"backup"}) {
+		t.Errorf("jobs ran = %v, want just backup", ran)
+	}
+
+	_, err := dispatch(context.Background(), []byte(`{"version":"0","detail-type":"Scheduled Event","source":"aws.events","resources":[],"detail":{"job":"digest"}}`))
+	if err != nil || !reflect.DeepEqual(ran, []string{"backup", "digest"}) {
+		t.Errorf("job from detail: ran %v, %v", ran, err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// withLogger carries the logger in the context, see loggerFrom
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// withLogAttrs adds attributes to every line logged from the context from here on
+func withLogAttrs(ctx context.Context, args ...any) context.Context {
+	return withLogger(ctx, loggerFrom(ctx).With(args...))
+}
+
+// loggerFrom is the logger for the request or job running in the context, or the default one outside of those
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// newRequestID is for requests that didn't come with an X-Request-ID, i.e. anything not through the lambda
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
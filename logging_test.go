@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func Test_requestLogging(t *testing.T) {
+	cfg := Config{JWTSecret: "test-secret"}
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second row doesn't parse
+	err = writeDataRecords(ctx, storage.Open, "alice", [][]string{{"2024-06-17", "30", "0.5"}, {"June 18th", "30", "0.5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _, err := issueJWT([]byte(cfg.JWTSecret), UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newServer(storage, cfg)
+	e.GET("/panic", func(c echo.Context) error { panic("oops") })
+	get := func(path, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		if requestID != "" {
+			req.Header.Set(echo.HeaderXRequestID, requestID)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	buf.Reset()
+	rec := get("/", "from-the-gateway")
+	if got := rec.Header().Get(echo.HeaderXRequestID); got != "from-the-gateway" {
+		t.Errorf("response request id = %q, want the one sent", got)
+	}
+	lines := logLines(t, &buf)
+	for _, msg := range []string{"Incoming Request", "storage operation", "skipping row", "Request Status"} {
+		l, ok := lines[msg]
+		if !ok {
+			t.Errorf("no %q line in %v", msg, lines)
+			continue
+		}
+		if l["request_id"] != "from-the-gateway" || l["method"] != "GET" || l["route"] != "/" {
+			t.Errorf("%q is missing request attributes: %v", msg, l)
+		}
+		if msg != "Incoming Request" && l["user"] != "alice" {
+			t.Errorf("%q is missing the user: %v", msg, l)
+		}
+	}
+
+	// one is made up when there isn't one, and it's on the panic too
+	buf.Reset()
+	rec = get("/panic", "")
+	requestID := rec.Header().Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		t.Fatal("no request id generated")
+	}
+	l, ok := logLines(t, &buf)["[PANIC RECOVER]"]
+	if !ok || l["request_id"] != requestID || l["route"] != "/panic" || l["err"] != "oops" {
+		t.Errorf("panic line = %v, want request %s", l, requestID)
+	}
+}
+
+// logLines are the JSON log lines by message, the last of each
+func logLines(t *testing.T, buf *bytes.Buffer) map[string]map[string]any {
+	lines := make(map[string]map[string]any)
+	s := bufio.NewScanner(buf)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var l map[string]any
+		err := json.Unmarshal(s.Bytes(), &l)
+		if err != nil {
+			t.Fatalf("bad log line %s: %v", s.Bytes(), err)
+		}
+		lines[l["msg"].(string)] = l
+	}
+	return lines
+}
@@ -1,292 +1,160 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"embed"
-	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/a-h/templ"
-	"github.com/golang-jwt/jwt"
-	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
 	"io"
-	"io/fs"
-	"log"
 	"log/slog"
 	"math"
 	"net/http"
 	"os"
-	"path/filepath"
 	"slices"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/aws/aws-lambda-go/lambda"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/a-h/templ"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:embed static/*
 var staticFiles embed.FS
 
-type UserInfo struct {
-	Username         string
-	Password         string
-	RestingHeartrate float64
-	DateOfBirth      time.Time
-}
-
 const moderateFloorPercentage = 0.5
 const highFloorPercentage = 0.7
 const minimumModerateIntensityHoursPerWeek = 2.5
 
 const jwtClaimsKey = "jwt-claims"
+const feedRoute = "/feed/:user/:token"
+const unsubscribeRoute = "/unsubscribe/:user/:token"
 const userInfoFileName = "user-info.json"
 const userDataFileName = "activity-tracker-data.csv"
 
+// fileHandlerFunc opens a user's file on whichever storage is configured
+type fileHandlerFunc func(ctx context.Context, username, fileName string) (io.ReadWriteCloser, error)
+
 // https://changelog.com/gotime/291
 // https://templ.guide/
 // https://github.com/a-h/templ
 // https://htmx.org/
 func main() {
+	configFile := flag.String("config", "", "yaml config file, or set CONFIG_FILE")
 	useLocalFile := flag.Bool("local-file", false, "use local instead of s3")
-	runLocally := flag.Bool("run-locally", false, "run locally instead of lambda")
-	initUser := flag.Bool("init-user", false, "run the initialize user command")
+	logLevel := flag.String("log-level", "", "debug, info, warn or error")
+	runLocally := flag.Bool("run-locally", false, "deprecated: use the serve command")
+	initUser := flag.Bool("init-user", false, "deprecated: use the user add command")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [--config file] [--local-file] <command> [args]\n%s", os.Args[0], commandUsage)
+		flag.PrintDefaults()
+	}
 
 	flag.Parse()
 
-	slog.SetDefault(setupLogger())
-
-	slog.Info("start up config",
-		"localFile", *useLocalFile,
-		"runLocally", *runLocally,
-	)
-
-	getFileHandler := func(ctx context.Context, username, fileName string) (io.ReadWriteCloser, error) {
+	cfg, err := loadConfig(*configFile, os.Getenv)
+	if err == nil {
+		// flags win over the file and environment
 		if *useLocalFile {
-			return &LocalFileData{
-				ctx:      ctx,
-				fileName: filepath.Join("localdata", username, fileName),
-			}, nil
+			cfg.Storage.Local = true
 		}
-
-		// else s3
-		cfg, err := config.LoadDefaultConfig(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load aws config: %w", err)
+		if *logLevel != "" {
+			cfg.LogLevel = *logLevel
 		}
-		return &S3FileData{
-			ctx:      ctx,
-			s3Client: s3.NewFromConfig(cfg),
-			bucket:   "activity-tracker-lambda-artifacts",
-			key:      fmt.Sprintf("data/%s/%s", username, fileName),
-		}, nil
+		err = cfg.validate()
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	if *initUser {
-		userInfo, _ := userInfoFromIO(os.Stdin, os.Stdout)
-		f, err := getFileHandler(context.Background(), userInfo.Username, userInfoFileName)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer safeClose(f, "init-user-info")
-		err = json.NewEncoder(f).Encode(userInfo)
-		if err != nil {
-			log.Fatal(err)
-		}
+	level, _ := cfg.slogLevel()
+	slog.SetDefault(setupLogger(level))
+	err = setupTracing(context.Background(), cfg.TracesExporter)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-		f, err = getFileHandler(context.Background(), userInfo.Username, userDataFileName)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer safeClose(f, "init-user-data")
+	args := flag.Args()
+	switch {
+	case *initUser:
+		args = append([]string{"user", "add"}, args...)
+	case *runLocally:
+		args = append([]string{"serve"}, args...)
+	case len(args) == 0:
+		// lambda runs the binary without arguments
+		args = []string{"lambda"}
+	}
 
-		// this creates the file if it doesn't exist, but doesn't erase any data
-		b, _ := io.ReadAll(f)
-		_, _ = f.Write(b)
+	slog.Info("start up config",
+		"localFile", cfg.Storage.Local,
+		"command", args[0],
+	)
 
+	err = runCommand(context.Background(), &cli{
+		config:  cfg,
+		storage: newStorage(cfg.Storage),
+		backups: newBackupStore(cfg.Storage),
+		in:      os.Stdin,
+		out:     os.Stdout,
+	}, args)
+	// the webhooks an entry command started sending
+	webhookSends.Wait()
+	shutdownTracing(context.Background())
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
 
-	e := echo.New()
-	e.Use(Recover())
-	e.Use(RequestLogger())
-
-	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			if c.Path() == "/login" {
-				return next(c)
-			}
-			s, err := c.Cookie("session")
-			if err == nil {
-				claims, jwtErr := parseJWT(s.Value)
-				if jwtErr == nil {
-					c.Set(jwtClaimsKey, claims)
-					return next(c)
-				}
-				err = jwtErr
-			}
-			slog.Info("301 for user", "err", err.Error())
-			return c.Redirect(http.StatusFound, "/login")
-		}
-	})
-
-	e.GET("/login", func(c echo.Context) error {
-		return render(c, page(loginForm()))
-	})
-
-	e.POST("/login", func(c echo.Context) error {
-		var params struct {
-			Username string `form:"username"`
-			Password string `form:"password"`
-		}
-		// todo add validation
-		if err := c.Bind(&params); err != nil {
-			return err
-		}
-		infoFile, err := getFileHandler(c.Request().Context(), params.Username, userInfoFileName)
-		if err != nil {
-			slog.Warn("failed getting user info", "user", params.Username, "err", err)
-			// assume it doesn't exist  for now
-			return c.NoContent(http.StatusUnauthorized)
-		}
-		defer safeClose(infoFile, "login")
-		var userInfo UserInfo
-		err = json.NewDecoder(infoFile).Decode(&userInfo)
-		if err != nil {
-			slog.Warn("failed parsing user info", "user", params.Username, "err", err)
-			return c.NoContent(http.StatusInternalServerError)
-		}
-
-		err = bcrypt.CompareHashAndPassword([]byte(userInfo.Password), []byte(params.Password))
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return c.NoContent(http.StatusUnauthorized)
-		}
-		if err != nil {
-			slog.Warn("failed comparing password", "user", params.Username, "err", err)
-			return c.NoContent(http.StatusUnauthorized)
-		}
-
-		// https://echo.labstack.com/docs/cookies
-		value, exp, err := issueJWT(userInfo)
-		if err != nil {
-			return err
-		}
-		c.SetCookie(&http.Cookie{
-			Name:    "session",
-			Value:   value,
-			Expires: exp,
-		})
-		return c.Redirect(http.StatusFound, "/")
-	})
-
-	e.GET("", func(c echo.Context) error {
-		claims := c.Get(jwtClaimsKey).(JWTClaims)
-		f, err := getFileHandler(c.Request().Context(), claims.User, userDataFileName)
-		if err != nil {
-			return err
-		}
-		defer safeClose(f, "get data")
-
-		days, err := readCSV(f)
-		if err != nil {
-			return fmt.Errorf("reading days: %w", err)
-		}
-
-		days = fillInDates(days, time.Now())
-		summary := calcSummary(claims, days[:7])
-
-		return render(c, page(mainContent(summarySection(summary), tracker(days, summary))))
-	})
-
-	e.POST("/entries", func(c echo.Context) error {
-
-		var params struct {
-			Date        string  `form:"date"`
-			Duration    string  `form:"duration"`
-			Effort      float32 `form:"effort"`
-			Description string  `form:"description"`
-		}
-
-		if err := c.Bind(&params); err != nil {
-			return fmt.Errorf("failed to marshal body")
-		}
-
-		claims := c.Get(jwtClaimsKey).(JWTClaims)
-		f, err := getFileHandler(c.Request().Context(), claims.User, userDataFileName)
-		if err != nil {
-			return err
-		}
-		defer safeClose(f, "get data")
-
-		date, err := time.Parse(time.DateOnly, params.Date)
-		if err != nil {
-			return c.NoContent(http.StatusNotAcceptable)
-		}
-		duration, err := time.ParseDuration(params.Duration)
-		if err != nil {
-			return c.NoContent(http.StatusNotAcceptable)
-		}
-
-		entry := DayEntry{
-			Duration:    duration,
-			Effort:      params.Effort,
-			Description: params.Description,
-		}
-
-		err = addCSVEntries([]DayLog{{
-			Date:    date,
-			Entries: []DayEntry{entry},
-		}}, f)
-		if err != nil {
-			return err
-		}
-		return render(c, entryDisplay(entry))
-	})
-
-	e.GET("/add-entry-modal", func(c echo.Context) error {
-		var params struct {
-			Date string `query:"date"`
-		}
-
-		if err := c.Bind(&params); err != nil {
-			return fmt.Errorf("failed to marshal body")
-		}
-		return render(c, addLogModal(params.Date))
-	})
+// trackerView is everything shown on the main page
+type trackerView struct {
+	Days         []DayLog // descending and filled in, see fillInDates
+	Summary      Summary
+	Achievements Achievements
+}
 
-	e.POST("/logout", func(c echo.Context) error {
-		c.SetCookie(&http.Cookie{
-			Name:    "session",
-			Value:   "invalid",
-			Expires: time.Now().Add(-1 * time.Hour),
-		})
-		return c.Redirect(http.StatusFound, "/login")
-	})
+func loadTrackerView(ctx context.Context, getFileHandler fileHandlerFunc, claims JWTClaims, today time.Time) (trackerView, error) {
+	// the achievements need everything up to today, in the segmented layout the months are cached
+	days, err := readDaysBetween(ctx, getFileHandler, claims.User, time.Time{}, today)
+	if err != nil {
+		return trackerView{}, err
+	}
 
-	fsys, err := fs.Sub(staticFiles, "static")
+	activityTypes, err := loadActivityTypes(ctx, getFileHandler, claims.User)
 	if err != nil {
-		panic(err)
+		return trackerView{}, err
 	}
-	fileHandler := http.FileServer(http.FS(fsys))
-	e.GET("/styles.css", echo.WrapHandler(fileHandler))
+	model := scoringModelFor(claims.ScoringModel, activityTypes)
 
-	if *runLocally {
-		slog.Info("Starting local execution")
-		_ = e.Start(":8080")
-	} else {
-		slog.Info("Starting lambda execution")
-		lambda.Start(LambdaEchoProxy(e))
+	days = fillInDates(days, today)
+	_, span := tracer.Start(ctx, "Summarize", trace.WithAttributes(attribute.String("scoring.model", model.Name()), attribute.Int("days", len(days))))
+	v := trackerView{
+		Days:         days,
+		Summary:      model.Summarize(claims, days[:7]),
+		Achievements: calcAchievements(model, claims, days),
 	}
+	span.End()
+	return v, nil
+}
 
+// day returns the displayed day for the date, if it is displayed
+func (v trackerView) day(date time.Time) (DayLog, bool) {
+	for _, d := range v.Days {
+		if d.Date.Equal(date) {
+			return d, true
+		}
+	}
+	return DayLog{}, false
 }
 
 type Summary struct {
@@ -301,7 +169,11 @@ type Summary struct {
 	HighIntensityScore         float64
 	ComboScore                 float64 // high intensity is about double time, 100 is goal
 	RemainingModerateTime      time.Duration
-	BonusLevel                 float64 // 5h equiv, 200%
+	BonusLevel                 float64   // 5h equiv, 200%
+	TypeSums                   []TypeSum // per activity type, largest first
+	Model                      string    // name of the ScoringModel that calculated this
+	METMinutes                 float64   // only calculated by the met-minutes model
+	METMinutesGoal             float64
 }
 
 func calcSummary(claims JWTClaims, days []DayLog) Summary {
@@ -310,6 +182,7 @@ func calcSummary(claims JWTClaims, days []DayLog) Summary {
 	maximumHeartRate := 206.09 - 0.67*age
 
 	s := Summary{
+		Model:                      effortScoringModelName,
 		RestingHeartRate:           claims.RestingHeartrate,
 		ModerateIntensityHeartRate: maximumHeartRate * moderateFloorPercentage,
 		HighIntensityHeartRate:     maximumHeartRate * highFloorPercentage,
@@ -345,30 +218,69 @@ func calcSummary(claims JWTClaims, days []DayLog) Summary {
 
 	s.ComboScore = s.LowIntensityScore + s.ModerateIntensityScore + s.HighIntensityScore
 	s.BonusLevel = 200
+	s.TypeSums = calcTypeSums(days)
 
 	return s
 }
 
 type (
 	DayLog struct {
-		Date    time.Time
-		Entries []DayEntry
+		Date    time.Time  `json:"date"`
+		Entries []DayEntry `json:"entries"`
 	}
 
 	DayEntry struct {
-		Duration    time.Duration
-		Effort      float32
-		Description string
+		Duration    time.Duration `json:"duration"` // nanoseconds
+		Effort      float32       `json:"effort"`
+		Type        string        `json:"type,omitempty"` // see ActivityType, may be free text or empty
+		Description string        `json:"description"`
+		StartTime   time.Time     `json:"startTime,omitempty"` // zero if only the duration is known
 	}
 )
 
+// entryJSON is a DayEntry as json, omitempty doesn't leave out a zero time.Time
+type entryJSON struct {
+	dayEntryFields
+	StartTime *time.Time `json:"startTime,omitempty"`
+}
+
+// dayEntryFields doesn't have DayEntry's MarshalJSON
+type dayEntryFields DayEntry
+
+func (e DayEntry) toJSON() entryJSON {
+	j := entryJSON{dayEntryFields: dayEntryFields(e)}
+	if !e.StartTime.IsZero() {
+		j.StartTime = &e.StartTime
+	}
+	return j
+}
+
+func (e DayEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.toJSON())
+}
+
+// Label is the text shown for an entry, falling back to the type if there's no description
+func (e DayEntry) Label() string {
+	if e.Description == "" {
+		return e.Type
+	}
+	return e.Description
+}
+
 func render(c echo.Context, comp templ.Component) error {
-	err := comp.Render(c.Request().Context(), c.Response())
+	return renderStatus(c, http.StatusOK, comp)
+}
+
+// renderStatus renders to a buffer first, so a failure part way through can still be an error page
+func renderStatus(c echo.Context, status int, comp templ.Component) error {
+	ctx, span := tracer.Start(c.Request().Context(), "render")
+	var buf bytes.Buffer
+	err := comp.Render(ctx, &buf)
+	endSpan(span, err)
 	if err != nil {
-		slog.Error("rendering component", "err", err)
-		return c.HTML(http.StatusOK, "Error") // todo
+		return fmt.Errorf("rendering: %w", err)
 	}
-	return nil
+	return c.HTMLBlob(status, buf.Bytes())
 }
 
 func effortColor(e DayEntry) string {
@@ -386,112 +298,6 @@ func effortColor(e DayEntry) string {
 	return color
 }
 
-type LocalFileData struct {
-	ctx      context.Context
-	fileName string
-	writer   io.WriteCloser
-	reader   io.ReadCloser
-}
-
-func (l *LocalFileData) Read(p []byte) (n int, err error) {
-	if l.reader == nil {
-		slog.Info("opening local file")
-
-		err = os.MkdirAll(filepath.Dir(l.fileName), 0755)
-		if err != nil {
-			return 0, fmt.Errorf("failed to mkdir -p: %w", err)
-		}
-
-		f, err := os.Open(l.fileName)
-		if err != nil {
-			return 0, fmt.Errorf("failed to open file for reading: %w", err)
-		}
-		l.reader = f
-	}
-	return l.reader.Read(p)
-}
-
-func (l *LocalFileData) Write(p []byte) (n int, err error) {
-	if l.writer == nil {
-		slog.Info("writing to local file")
-
-		err = os.MkdirAll(filepath.Dir(l.fileName), 0755)
-		if err != nil {
-			return 0, fmt.Errorf("failed to mkdir -p: %w", err)
-		}
-
-		f, err := os.Create(l.fileName)
-		if err != nil {
-			return 0, fmt.Errorf("failed to open file for writing: %w", err)
-		}
-		l.writer = f
-	}
-	return l.writer.Write(p)
-}
-
-func (l *LocalFileData) Close() error {
-	if l.reader != nil {
-		return l.reader.Close()
-	}
-	if l.writer != nil {
-		return l.writer.Close()
-	}
-	return nil
-}
-
-type S3FileData struct {
-	ctx      context.Context
-	s3Client *s3.Client
-	bucket   string
-	key      string
-
-	writer io.ReadWriter
-	reader io.ReadCloser
-}
-
-func (s *S3FileData) Read(p []byte) (n int, err error) {
-	if s.reader == nil {
-		result, err := s.s3Client.GetObject(s.ctx, &s3.GetObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(s.key),
-		})
-		if err != nil {
-			return 0, fmt.Errorf("could not get object: %w", err)
-		}
-		s.reader = result.Body
-	}
-	return s.reader.Read(p)
-}
-
-func (s *S3FileData) Write(p []byte) (n int, err error) {
-	if s.writer == nil {
-		s.writer = &bytes.Buffer{}
-	}
-	return s.writer.Write(p)
-}
-
-func (s *S3FileData) Close() error {
-	if s.writer != nil {
-		_, err := s.s3Client.PutObject(s.ctx, &s3.PutObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(s.key),
-			Body:   s.writer,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to write data to s3: %w", err)
-		}
-		s.writer = nil
-	}
-	if s.reader != nil {
-		err := s.reader.Close()
-		s.reader = nil
-		if err != nil {
-			return fmt.Errorf("failed to closer a reader: %w", err)
-		}
-	}
-	return nil
-}
-
 func toCSVRecords(logs []DayLog) [][]string {
 	records := make([][]string, 0)
 	for _, l := range logs {
@@ -501,73 +307,79 @@ func toCSVRecords(logs []DayLog) [][]string {
 				e.Duration.String(),
 				fmt.Sprintf("%.2f", e.Effort),
 				e.Description,
+				e.Type,
+				startTimeStr(e.StartTime),
 			})
 		}
 	}
 	return records
 }
 
-func addCSVEntries(logs []DayLog, c io.ReadWriteCloser) error {
-	contents, err := io.ReadAll(c)
-	if err != nil {
-		return fmt.Errorf("failed to read all of csv: %w", err)
+func startTimeStr(t time.Time) string {
+	if t.IsZero() {
+		return ""
 	}
+	return t.Format("15:04")
+}
 
-	b := bytes.NewBuffer(contents)
+// parseCSVRecord parses one row written by toCSVRecords, older rows have 4 or 5 columns
+func parseCSVRecord(r []string) (time.Time, DayEntry, error) {
+	if len(r) < 4 || len(r) > 6 {
+		return time.Time{}, DayEntry{}, fmt.Errorf("incorrect number of columns %d", len(r))
+	}
 
-	records := toCSVRecords(logs)
-	err = csv.NewWriter(b).WriteAll(records)
+	date, err := time.Parse(time.DateOnly, r[0])
 	if err != nil {
-		return fmt.Errorf("failed to add to csv buffer: %w", err)
+		return time.Time{}, DayEntry{}, fmt.Errorf("error parsing date: %w", err)
 	}
 
-	_, err = c.Write(b.Bytes())
+	duration, err := time.ParseDuration(r[1])
 	if err != nil {
-		return fmt.Errorf("failed to add write to csv: %w", err)
+		return time.Time{}, DayEntry{}, fmt.Errorf("error parsing duration: %w", err)
 	}
-	return nil
-}
 
-func readCSV(file io.ReadCloser) ([]DayLog, error) {
-	// Read the CSV
-	records, err := csv.NewReader(file).ReadAll()
+	effort, err := strconv.ParseFloat(r[2], 32)
 	if err != nil {
-		return nil, fmt.Errorf("reading csv: %w", err)
+		return time.Time{}, DayEntry{}, fmt.Errorf("error parsing effort: %w", err)
 	}
 
-	entriesPerDay := make(map[string][]DayEntry)
-	for i, r := range records {
-		if len(r) != 4 {
-			fmt.Println("incorrect number of columns for row ", i)
-			continue
-		}
-
-		dateStr := r[0]
-		_, err := time.Parse(time.DateOnly, dateStr)
+	entry := DayEntry{
+		Duration:    duration,
+		Effort:      float32(effort),
+		Description: r[3],
+	}
+	if len(r) > 4 {
+		entry.Type = r[4]
+	}
+	if len(r) > 5 && r[5] != "" {
+		entry.StartTime, err = time.Parse(time.DateOnly+" 15:04", r[0]+" "+r[5])
 		if err != nil {
-			fmt.Println("error parsing date of row ", i, err)
-			continue
+			return time.Time{}, DayEntry{}, fmt.Errorf("error parsing start time: %w", err)
 		}
+	}
+	return date, entry, nil
+}
 
-		duration, err := time.ParseDuration(r[1])
-		if err != nil {
-			fmt.Println("error parsing duration of row ", i, err)
-			continue
-		}
+// readCSV reads a data file of any version, see decodeData
+func readCSV(ctx context.Context, file io.Reader) ([]DayLog, error) {
+	_, records, err := decodeData(file)
+	if err != nil {
+		return nil, err
+	}
+	return daysFromRecords(ctx, records), nil
+}
 
-		effort, err := strconv.ParseFloat(r[2], 32)
+// daysFromRecords skips any rows that don't parse, most recent day first
+func daysFromRecords(ctx context.Context, records [][]string) []DayLog {
+	entriesPerDay := make(map[string][]DayEntry)
+	for i, r := range records {
+		date, entry, err := parseCSVRecord(r)
 		if err != nil {
-			fmt.Println("error parsing effort of row ", i, err)
+			loggerFrom(ctx).Warn("skipping row", "row", i, "err", err)
 			continue
 		}
-
-		description := r[3]
-
-		entriesPerDay[dateStr] = append(entriesPerDay[dateStr], DayEntry{
-			Duration:    duration,
-			Effort:      float32(effort),
-			Description: description,
-		})
+		dateStr := date.Format(time.DateOnly)
+		entriesPerDay[dateStr] = append(entriesPerDay[dateStr], entry)
 	}
 
 	var dayLogs []DayLog
@@ -590,12 +402,16 @@ func readCSV(file io.ReadCloser) ([]DayLog, error) {
 
 	// fill in dates
 
-	return dayLogs, nil
+	return dayLogs
 }
 
 func fillInDates(dayLogs []DayLog, upTo time.Time) []DayLog {
 	// truncate to date
 	upTo = time.Date(upTo.Year(), upTo.Month(), upTo.Day(), 0, 0, 0, 0, time.UTC)
+	// anything later isn't shown, like future-dated entries or a summary of a past week
+	for len(dayLogs) > 0 && dayLogs[0].Date.After(upTo) {
+		dayLogs = dayLogs[1:]
+	}
 	earliest := upTo.AddDate(0, 0, -13)
 	if len(dayLogs) > 0 {
 		last := dayLogs[len(dayLogs)-1].Date
@@ -617,10 +433,10 @@ func fillInDates(dayLogs []DayLog, upTo time.Time) []DayLog {
 	return dayLogs
 }
 
-func safeClose(c io.Closer, name string) {
+func safeClose(ctx context.Context, c io.Closer, name string) {
 	err := c.Close()
 	if err != nil {
-		slog.Error("failed to close closer", "name", name, "err", err)
+		loggerFrom(ctx).Error("failed to close closer", "name", name, "err", err)
 	}
 }
 
@@ -629,6 +445,7 @@ type JWTClaims struct {
 	Expiration       int64     `json:"exp"`
 	RestingHeartrate float64   `json:"heart"`
 	DateOfBirth      time.Time `json:"dob"`
+	ScoringModel     string    `json:"scoring,omitempty"`
 }
 
 func (c JWTClaims) Valid() error {
@@ -641,20 +458,24 @@ func (c JWTClaims) Valid() error {
 	return nil
 }
 
-func issueJWT(userInfo UserInfo) (string, time.Time, error) {
-	exp := time.Now().AddDate(20, 0, 0)
-
-	claims := JWTClaims{
+func newJWTClaims(userInfo UserInfo, exp time.Time) JWTClaims {
+	return JWTClaims{
 		User:             userInfo.Username,
 		Expiration:       exp.Unix(),
 		RestingHeartrate: userInfo.RestingHeartrate,
 		DateOfBirth:      userInfo.DateOfBirth,
+		ScoringModel:     userInfo.ScoringModel,
 	}
+}
+
+func issueJWT(secret []byte, userInfo UserInfo) (string, time.Time, error) {
+	exp := time.Now().AddDate(20, 0, 0)
+
+	claims := newJWTClaims(userInfo, exp)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := token.SignedString(secret)
 	if err != nil {
 		return "", exp, err
 	}
@@ -662,13 +483,27 @@ func issueJWT(userInfo UserInfo) (string, time.Time, error) {
 	return tokenString, exp, nil
 }
 
-func parseJWT(tokenString string) (JWTClaims, error) {
+// setSessionCookie issues a JWT for the user and sets it as the session cookie
+// https://echo.labstack.com/docs/cookies
+func setSessionCookie(c echo.Context, secret []byte, userInfo UserInfo) error {
+	value, exp, err := issueJWT(secret, userInfo)
+	if err != nil {
+		return err
+	}
+	c.SetCookie(&http.Cookie{
+		Name:    "session",
+		Value:   value,
+		Expires: exp,
+	})
+	return nil
+}
+
+func parseJWT(secret []byte, tokenString string) (JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (any, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		jwtSecret := []byte(os.Getenv("JWT_SECRET"))
-		return jwtSecret, nil
+		return secret, nil
 	})
 
 	if err != nil {
@@ -682,43 +517,18 @@ func parseJWT(tokenString string) (JWTClaims, error) {
 	return JWTClaims{}, fmt.Errorf("invalid token")
 }
 
-func userInfoFromIO(r io.Reader, w io.Writer) (UserInfo, error) {
-	u := UserInfo{}
-
-	buffedReader := bufio.NewReader(r)
-
-	// todo input validation and error handling
-	_, _ = w.Write([]byte("username: "))
-	u.Username, _ = buffedReader.ReadString('\n')
-	u.Username = strings.TrimSpace(u.Username)
-
-	_, _ = w.Write([]byte("password: "))
-	password, _ := buffedReader.ReadString('\n')
-	password = strings.TrimSpace(password)
-	passwordBytes, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	u.Password = string(passwordBytes)
-
-	_, _ = w.Write([]byte("resting heart rate: "))
-	heartRateStr, _ := buffedReader.ReadString('\n')
-	heartRateStr = strings.TrimSpace(heartRateStr)
-	u.RestingHeartrate, _ = strconv.ParseFloat(heartRateStr, 10)
-
-	for u.DateOfBirth.IsZero() {
-		_, _ = w.Write([]byte(fmt.Sprintf("date of birth (%s): ", time.DateOnly)))
-		dob, _ := buffedReader.ReadString('\n')
-		dob = strings.TrimSpace(dob)
-		u.DateOfBirth, _ = time.Parse(time.DateOnly, dob)
-	}
-
-	return u, nil
-}
-
-var version = "unknown" // filled in during goreleaser build
+// filled in during goreleaser build, see https://goreleaser.com/cookbooks/using-main.version/
+var (
+	version = "unknown"
+	commit  = "unknown"
+	date    = "unknown"
+)
 
-func setupLogger() *slog.Logger {
-	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+func setupLogger(level slog.Level) *slog.Logger {
+	// stderr so stdout is left for command output, lambda collects both
+	h := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
 		AddSource:   false,
-		Level:       slog.LevelDebug,
+		Level:       level,
 		ReplaceAttr: nil,
 	})
 	l := slog.New(h)
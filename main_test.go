@@ -2,6 +2,7 @@ package main
 
 import (
 	"reflect"
+	"slices"
 	"testing"
 	"time"
 )
@@ -27,6 +28,10 @@ func Test_fillInDates(t *testing.T) {
 		{Date: time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC)},
 	}
 
+	// an entry on the 15th, among the blanks
+	with15th := slices.Clone(past14Days)
+	with15th[2].Entries = []DayEntry{{Duration: time.Hour}}
+
 	type args struct {
 		dayLogs []DayLog
 		upTo    time.Time
@@ -99,6 +104,17 @@ func Test_fillInDates(t *testing.T) {
 				DayLog{Date: time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
 			),
 		},
+		{
+			name: "newer-than-up-to",
+			args: args{
+				dayLogs: []DayLog{
+					{Date: time.Date(2024, 6, 22, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: time.Hour}}},
+					{Date: time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: time.Hour}}},
+				},
+				upTo: today,
+			},
+			want: with15th,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsRoute = "/metrics"
+
+// usageCacheFor is how long the usage gauges are kept, they read every user's data
+const usageCacheFor = 5 * time.Minute
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "activity_tracker_http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "activity_tracker_http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	storageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "activity_tracker_storage_operation_duration_seconds",
+		Help:    "Storage operation latency by backend (local, s3) and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+
+	storageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "activity_tracker_storage_errors_total",
+		Help: "Failed storage operations by backend and operation, files not existing yet aren't counted.",
+	}, []string{"backend", "operation"})
+
+	loginDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "activity_tracker_login_bcrypt_duration_seconds",
+		Help:    "Time spent comparing password hashes on login.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 10),
+	})
+
+	panicsRecovered = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "activity_tracker_panics_recovered_total",
+		Help: "Panics caught by the Recover middleware.",
+	})
+
+	entriesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "activity_tracker_entries_created_total",
+		Help: "Entries added, including imports, since the process started.",
+	})
+
+	dayCacheResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "activity_tracker_day_cache_total",
+		Help: "Reads of users' entries by whether the parsed copy was still current (hit) or the file was read (miss).",
+	}, []string{"result"})
+)
+
+// newMetricsRegistry has the process-wide metrics plus usage gauges read from storage
+func newMetricsRegistry(storage Storage) *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		storageDuration, storageErrors,
+		loginDuration, panicsRecovered, entriesCreated, dayCacheResults,
+		&usageCollector{storage: storage, now: time.Now},
+	)
+	return r
+}
+
+// addMetricsRoute serves the registry to requests with "Authorization: Bearer <token>"
+func addMetricsRoute(e *echo.Echo, registry *prometheus.Registry, token string) {
+	handler := echo.WrapHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	e.GET(metricsRoute, func(c echo.Context) error {
+		got, _ := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !validSecretToken(token, got) {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		return handler(c)
+	})
+}
+
+// observeStorage records how long an operation on the backend took, and whether it failed
+func observeStorage(backend, operation string, start time.Time, err error) {
+	storageDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil && !isNotExist(err) {
+		storageErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+var (
+	usersDesc        = prometheus.NewDesc("activity_tracker_users", "Users with an account.", nil, nil)
+	entriesTodayDesc = prometheus.NewDesc("activity_tracker_entries_today", "Entries dated today, across all users.", nil, nil)
+	activeUsersDesc  = prometheus.NewDesc("activity_tracker_active_users_7d", "Users with an entry in the last seven days.", nil, nil)
+)
+
+// usageCollector reads the gauges from storage when scraped, at most every usageCacheFor
+type usageCollector struct {
+	storage Storage
+	now     func() time.Time
+
+	mu      sync.Mutex
+	read    time.Time
+	metrics []prometheus.Metric
+}
+
+func (u *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+	ch <- entriesTodayDesc
+	ch <- activeUsersDesc
+}
+
+func (u *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := u.now()
+	if now.Sub(u.read) > usageCacheFor {
+		// scrapes don't pass a context on
+		ctx := withLogAttrs(context.Background(), "job", "usage metrics")
+		metrics, err := u.collect(ctx, now)
+		if err != nil {
+			loggerFrom(ctx).Warn("failed reading usage metrics", "err", err)
+			ch <- prometheus.NewInvalidMetric(usersDesc, err)
+			return
+		}
+		u.read = now
+		u.metrics = metrics
+	}
+	for _, m := range u.metrics {
+		ch <- m
+	}
+}
+
+func (u *usageCollector) collect(ctx context.Context, now time.Time) ([]prometheus.Metric, error) {
+	users, err := u.storage.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	weekAgo := today.AddDate(0, 0, -6)
+	entriesToday, activeUsers := 0, 0
+	for _, username := range users {
+		days, err := readDaysBetween(ctx, u.storage.Open, username, weekAgo, today)
+		if err != nil && !isNotExist(err) {
+			return nil, err
+		}
+		active := false
+		for _, d := range days {
+			if d.Date.Equal(today) {
+				entriesToday += len(d.Entries)
+			}
+			if !d.Date.Before(weekAgo) && !d.Date.After(today) && len(d.Entries) > 0 {
+				active = true
+			}
+		}
+		if active {
+			activeUsers++
+		}
+	}
+
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(len(users))),
+		prometheus.MustNewConstMetric(entriesTodayDesc, prometheus.GaugeValue, float64(entriesToday)),
+		prometheus.MustNewConstMetric(activeUsersDesc, prometheus.GaugeValue, float64(activeUsers)),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_metrics(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	err := createUser(ctx, storage.Open, UserInfo{Username: "alice", Password: "$2a$04$invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = addEntry(ctx, storage.Open, "alice", time.Now(), DayEntry{Duration: time.Hour, Effort: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newServer(storage, Config{})
+	addMetricsRoute(e, newMetricsRegistry(storage), "scrape-me")
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	form := url.Values{"username": {"alice"}, "password": {"wrong"}}
+	login := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	login.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	do(login)
+
+	if rec := do(httptest.NewRequest(http.MethodGet, metricsRoute, nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without the token got %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, metricsRoute, nil)
+	req.Header.Set("Authorization", "Bearer scrape-me")
+	rec := do(req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("with the token got %d, want 200", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		`activity_tracker_http_requests_total{method="POST",route="/login",status="401"}`,
+		`activity_tracker_http_request_duration_seconds_bucket{method="POST",route="/login",status="401",le="+Inf"}`,
+		`activity_tracker_storage_operation_duration_seconds_count{backend="local",operation="get"}`,
+		`activity_tracker_login_bcrypt_duration_seconds_count`,
+		`activity_tracker_panics_recovered_total`,
+		`activity_tracker_entries_created_total`,
+		"activity_tracker_users 1\n",
+		"activity_tracker_entries_today 1\n",
+		"activity_tracker_active_users_7d 1\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+// notificationKind is which email, it is also what an unsubscribe link turns off
+type notificationKind string
+
+const (
+	notifyReminder notificationKind = "reminder"
+	notifyDigest   notificationKind = "digest"
+	notifyAll      notificationKind = "all" // only for unsubscribing
+)
+
+const (
+	defaultReminderRemaining = time.Hour
+	defaultReminderDaysLeft  = 2
+)
+
+// NotificationSettings are the emails a user has opted in to
+type NotificationSettings struct {
+	Email             string
+	Reminder          bool          // mid-week nudge when behind on the weekly goal
+	ReminderRemaining time.Duration // sent when more than this moderate time remains...
+	ReminderDaysLeft  int           // ...with this many days of the week left after today
+	Digest            bool          // Sunday summary of the week
+	UnsubscribeToken  string        // secret for the unsubscribe links
+}
+
+func (s NotificationSettings) Enabled(kind notificationKind) bool {
+	switch kind {
+	case notifyReminder:
+		return s.Reminder && s.Email != ""
+	case notifyDigest:
+		return s.Digest && s.Email != ""
+	}
+	return false
+}
+
+// Unsubscribe turns off kind, or every email for notifyAll
+func (s NotificationSettings) Unsubscribe(kind notificationKind) NotificationSettings {
+	if kind == notifyReminder || kind == notifyAll {
+		s.Reminder = false
+	}
+	if kind == notifyDigest || kind == notifyAll {
+		s.Digest = false
+	}
+	return s
+}
+
+// notificationsForm is the settings modal's fields
+type notificationsForm struct {
+	Email             string `form:"email"`
+	Reminder          bool   `form:"reminder"`
+	ReminderRemaining string `form:"remaining"`
+	ReminderDaysLeft  string `form:"daysLeft"`
+	Digest            bool   `form:"digest"`
+}
+
+func newNotificationsForm(s NotificationSettings) notificationsForm {
+	if s.ReminderRemaining == 0 {
+		s.ReminderRemaining = defaultReminderRemaining
+	}
+	if s.ReminderDaysLeft == 0 {
+		s.ReminderDaysLeft = defaultReminderDaysLeft
+	}
+	return notificationsForm{
+		Email:             s.Email,
+		Reminder:          s.Reminder,
+		ReminderRemaining: sumStr(s.ReminderRemaining),
+		ReminderDaysLeft:  strconv.Itoa(s.ReminderDaysLeft),
+		Digest:            s.Digest,
+	}
+}
+
+// apply validates the form onto the current settings, creating the unsubscribe token the first time
+func (f notificationsForm) apply(s NotificationSettings) (NotificationSettings, error) {
+	email := strings.TrimSpace(f.Email)
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			return s, fmt.Errorf("%q isn't an email address", email)
+		}
+		email = addr.Address
+	} else if f.Reminder || f.Digest {
+		return s, errors.New("an email address is needed to send anything")
+	}
+
+	remaining, err := parseFlexibleDuration(f.ReminderRemaining)
+	if err != nil || remaining <= 0 {
+		return s, fmt.Errorf("bad remaining time %q", f.ReminderRemaining)
+	}
+	daysLeft, err := strconv.Atoi(f.ReminderDaysLeft)
+	if err != nil || daysLeft < 1 || daysLeft > 6 {
+		return s, fmt.Errorf("days left must be 1 to 6")
+	}
+
+	s.Email = email
+	s.Reminder = f.Reminder
+	s.ReminderRemaining = remaining
+	s.ReminderDaysLeft = daysLeft
+	s.Digest = f.Digest
+	if s.UnsubscribeToken == "" {
+		s.UnsubscribeToken, err = newSecretToken()
+		if err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// mailer sends over SMTP, it's the smtp section of the Config
+type mailer struct {
+	Addr     string `yaml:"addr"`     // host:port, e.g. localhost:1025 for a local sink
+	Username string `yaml:"username"` // no auth if empty
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	BaseURL  string `yaml:"base_url"` // where the links in emails point, jobs don't have a request to take the host from
+}
+
+// unsubscribeURL turns off kind for the user, without logging in
+func (m mailer) unsubscribeURL(userInfo UserInfo, kind notificationKind) string {
+	return fmt.Sprintf("%s/unsubscribe/%s/%s?from=%s", m.BaseURL, url.PathEscape(userInfo.Username), userInfo.Notifications.UnsubscribeToken, kind)
+}
+
+// Send renders body as an html email, with List-Unsubscribe headers so mail clients can show their own button
+func (m mailer) Send(ctx context.Context, to, subject, unsubscribeURL string, body templ.Component) error {
+	if m.Addr == "" {
+		return errors.New("SMTP_ADDR is not set")
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("bad SMTP_FROM: %w", err)
+	}
+
+	var msg bytes.Buffer
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/html; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"List-Unsubscribe: <" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+	}
+	msg.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	err = body.Render(ctx, qp)
+	if err == nil {
+		err = qp.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("rendering email: %w", err)
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	err = smtp.SendMail(m.Addr, auth, from.Address, []string{to}, msg.Bytes())
+	if err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return nil
+}
+
+// reminderView is the mid-week nudge
+type reminderView struct {
+	Username       string
+	DaysLeft       int
+	Week           Summary // so far this week
+	UnsubscribeURL string
+}
+
+// digestView is the Sunday email
+type digestView struct {
+	Username       string
+	Monday         time.Time
+	Week           Summary
+	LastWeek       Summary
+	Streaks        []Streak
+	UnsubscribeURL string
+}
+
+// reminderFor is the reminder to send today, false if the user isn't behind or it isn't the day to send it
+// weeks start on Monday, like the weekly summaries in the calendar feed
+func reminderFor(model ScoringModel, claims JWTClaims, days []DayLog, settings NotificationSettings, now time.Time) (reminderView, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monday := startOfWeek(today)
+	daysLeft := 6 - int(today.Sub(monday).Hours()/24)
+	if daysLeft != settings.ReminderDaysLeft {
+		return reminderView{}, false
+	}
+
+	s := model.Summarize(claims, weekDays(daysByDate(days), monday))
+	if s.RemainingModerateTime <= settings.ReminderRemaining {
+		return reminderView{}, false
+	}
+	return reminderView{Username: claims.User, DaysLeft: daysLeft, Week: s}, true
+}
+
+// digestFor summarizes the week ending today if it is Sunday, otherwise the last full week
+func digestFor(model ScoringModel, claims JWTClaims, days []DayLog, now time.Time) digestView {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monday := startOfWeek(today)
+	if today.Weekday() != time.Sunday {
+		monday = monday.AddDate(0, 0, -7)
+	}
+	sunday := monday.AddDate(0, 0, 6)
+
+	byDate := daysByDate(days)
+	var upToSunday []DayLog
+	for _, d := range days {
+		if !d.Date.After(sunday) {
+			upToSunday = append(upToSunday, d)
+		}
+	}
+	return digestView{
+		Username: claims.User,
+		Monday:   monday,
+		Week:     model.Summarize(claims, weekDays(byDate, monday)),
+		LastWeek: model.Summarize(claims, weekDays(byDate, monday.AddDate(0, 0, -7))),
+		Streaks:  calcAchievements(model, claims, fillInDates(upToSunday, sunday)).Streaks,
+	}
+}
+
+// sendNotifications sends kind to everyone who opted in, or just username if given, returning who was sent one
+// a failure for one user doesn't stop the rest
+func sendNotifications(ctx context.Context, storage Storage, m mailer, kind notificationKind, username string, now time.Time) ([]string, error) {
+	users := []string{username}
+	if username == "" {
+		var err error
+		users, err = storage.ListUsers(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sent []string
+	var errs []error
+	for _, u := range users {
+		ok, err := notifyUser(ctx, storage.Open, m, kind, u, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s to %s: %w", kind, u, err))
+			continue
+		}
+		if ok {
+			sent = append(sent, u)
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+func notifyUser(ctx context.Context, getFileHandler fileHandlerFunc, m mailer, kind notificationKind, username string, now time.Time) (bool, error) {
+	userInfo, err := readUserInfo(ctx, getFileHandler, username)
+	if err != nil {
+		return false, err
+	}
+	settings := userInfo.Notifications
+	if !settings.Enabled(kind) {
+		return false, nil
+	}
+
+	// the reminder is about this week, the digest's streaks need everything
+	var from time.Time
+	if kind == notifyReminder {
+		from = startOfWeek(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+	}
+	model, days, err := loadScoring(ctx, getFileHandler, userInfo, from, time.Time{})
+	if err != nil {
+		return false, err
+	}
+	claims := newJWTClaims(userInfo, now)
+	unsubscribe := m.unsubscribeURL(userInfo, kind)
+
+	var subject string
+	var body templ.Component
+	switch kind {
+	case notifyReminder:
+		v, ok := reminderFor(model, claims, days, settings, now)
+		if !ok {
+			return false, nil
+		}
+		v.UnsubscribeURL = unsubscribe
+		subject = fmt.Sprintf("%s of moderate activity left this week", sumStr(v.Week.RemainingModerateTime))
+		body = reminderEmail(v)
+	case notifyDigest:
+		v := digestFor(model, claims, days, now)
+		v.UnsubscribeURL = unsubscribe
+		subject = fmt.Sprintf("Your week: %s of goal", scoreStr(v.Week.ComboScore))
+		body = digestEmail(v)
+	default:
+		return false, fmt.Errorf("unknown notification %q", kind)
+	}
+	return true, m.Send(ctx, settings.Email, subject, unsubscribe, body)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_reminderFor(t *testing.T) {
+	day := func(date string, minutes int) DayLog {
+		d, _ := time.Parse(time.DateOnly, date)
+		return DayLog{Date: d, Entries: []DayEntry{{Duration: time.Duration(minutes) * time.Minute, Effort: 0.6}}}
+	}
+	thursday := time.Date(2024, 6, 20, 18, 0, 0, 0, time.UTC) // 3 days left of the week
+	settings := NotificationSettings{Email: "a@example.com", Reminder: true, ReminderRemaining: time.Hour, ReminderDaysLeft: 3}
+
+	tests := []struct {
+		name          string
+		days          []DayLog
+		now           time.Time
+		want          bool
+		wantRemaining time.Duration
+	}{
+		{
+			name:          "behind",
+			days:          []DayLog{day("2024-06-18", 60), day("2024-06-16", 120)}, // sunday is last week
+			now:           thursday,
+			want:          true,
+			wantRemaining: 90 * time.Minute,
+		},
+		{
+			name: "close enough",
+			days: []DayLog{day("2024-06-18", 60), day("2024-06-17", 60)},
+			now:  thursday,
+		},
+		{
+			name: "not the day",
+			days: []DayLog{day("2024-06-18", 60)},
+			now:  thursday.AddDate(0, 0, -1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := reminderFor(effortScoringModel{}, JWTClaims{User: "alice"}, tt.days, settings, tt.now)
+			if ok != tt.want {
+				t.Fatalf("reminderFor() = %v, want %v", ok, tt.want)
+			}
+			if ok && (v.Week.RemainingModerateTime != tt.wantRemaining || v.DaysLeft != 3) {
+				t.Errorf("reminderFor() remaining %v with %d days left, want %v with 3", v.Week.RemainingModerateTime, v.DaysLeft, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func Test_digestFor(t *testing.T) {
+	days := []DayLog{
+		{Date: time.Date(2024, 6, 24, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: 30 * time.Minute, Effort: 0.6}}},
+		{Date: time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: 150 * time.Minute, Effort: 0.6}}},
+		{Date: time.Date(2024, 6, 12, 0, 0, 0, 0, time.UTC), Entries: []DayEntry{{Duration: 75 * time.Minute, Effort: 0.6}}},
+	}
+	monday := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+
+	// the Sunday it is sent, and a late run the week after, are both about the same week
+	for _, now := range []time.Time{time.Date(2024, 6, 23, 17, 0, 0, 0, time.UTC), time.Date(2024, 6, 26, 9, 0, 0, 0, time.UTC)} {
+		v := digestFor(effortScoringModel{}, JWTClaims{User: "alice"}, days, now)
+		if !v.Monday.Equal(monday) {
+			t.Errorf("%s: digest for week of %s, want %s", now.Weekday(), v.Monday.Format(time.DateOnly), monday.Format(time.DateOnly))
+		}
+		if v.Week.ComboScore != 100 || v.LastWeek.ComboScore != 50 {
+			t.Errorf("%s: scores %v and %v last week, want 100 and 50", now.Weekday(), v.Week.ComboScore, v.LastWeek.ComboScore)
+		}
+		if v.Streaks[0].Name != "Weekly Goal" || v.Streaks[0].Current != 1 {
+			t.Errorf("%s: streak %+v, want a weekly goal streak of 1", now.Weekday(), v.Streaks[0])
+		}
+	}
+}
+
+func Test_sendNotificationsAndUnsubscribe(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	addr, messages := smtpSink(t)
+	m := mailer{Addr: addr, From: "tracker@example.com", BaseURL: "http://tracker.example.com"}
+	sunday := time.Date(2024, 6, 23, 17, 0, 0, 0, time.UTC)
+
+	alice := UserInfo{Username: "alice", Notifications: NotificationSettings{Email: "alice@example.com", Digest: true, UnsubscribeToken: "secret"}}
+	for _, u := range []UserInfo{alice, {Username: "bob"}} {
+		err := createUser(ctx, storage.Open, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := addEntry(ctx, storage.Open, "alice", time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC), DayEntry{Duration: 75 * time.Minute, Effort: 0.6, Type: "run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err := sendNotifications(ctx, storage, m, notifyDigest, "", sunday)
+	if err != nil || len(sent) != 1 || sent[0] != "alice" {
+		t.Fatalf("sendNotifications() = %v, %v, want just alice", sent, err)
+	}
+	msg := <-messages
+	headers, body, _ := strings.Cut(msg, "\n\n")
+	html, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe := "http://tracker.example.com/unsubscribe/alice/secret?from=digest"
+	for _, want := range []string{"To: alice@example.com", "Subject: Your week: 50% of goal", "List-Unsubscribe: <" + unsubscribe + ">"} {
+		if !strings.Contains(headers, want) {
+			t.Errorf("headers missing %q:\n%s", want, headers)
+		}
+	}
+	for _, want := range []string{"Week of Jun 17", "1h15m run", "up 50 points on last week", unsubscribe} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("email missing %q:\n%s", want, html)
+		}
+	}
+
+	// what a mail client's one-click unsubscribe sends
+	e := newServer(storage, Config{})
+	post := func(target string) int {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader("List-Unsubscribe=One-Click"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := post("/unsubscribe/alice/wrong?from=digest"); code != http.StatusNotFound {
+		t.Errorf("wrong token got %d, want 404", code)
+	}
+	if code := post("/unsubscribe/alice/secret?from=digest"); code != http.StatusOK {
+		t.Fatalf("unsubscribe got %d, want 200", code)
+	}
+	userInfo, err := readUserInfo(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userInfo.Notifications.Digest || userInfo.Notifications.Email == "" {
+		t.Errorf("after unsubscribing settings are %+v, want the digest off and the rest kept", userInfo.Notifications)
+	}
+
+	sent, err = sendNotifications(ctx, storage, m, notifyDigest, "", sunday)
+	if err != nil || len(sent) != 0 {
+		t.Errorf("sendNotifications() after unsubscribing = %v, %v", sent, err)
+	}
+}
+
+// smtpSink is just enough of an SMTP server for net/smtp, returning its address and each message it receives
+func smtpSink(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return l.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	tp := textproto.NewConn(conn)
+	defer tp.Close()
+	_ = tp.PrintfLine("220 localhost")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, _, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			messages <- string(data)
+			_ = tp.PrintfLine("250 ok")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
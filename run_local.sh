@@ -1,4 +1,7 @@
 export JWT_SECRET=extremely-secret
+# e.g. mailhog, to see notification emails at http://localhost:8025
+export SMTP_ADDR=localhost:1025
+export METRICS_TOKEN=local-metrics
 
 # https://github.com/cespare/reflex
-reflex -r '\.(go|css)$' -s -- sh -c 'go run . --localFile --runLocally'
\ No newline at end of file
+reflex -r '\.(go|css)$' -s -- sh -c 'go run . --local-file serve'
\ No newline at end of file
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	effortScoringModelName     = "effort"
+	metMinutesScoringModelName = "met-minutes"
+)
+
+// https://www.who.int/publications/i/item/9789240015128 - 500-1000 MET-min/week
+const metMinutesGoalPerWeek = 500.0
+const metMinutesBonusPerWeek = 1000.0
+const moderateMET = 3.0 // the floor of moderate intensity
+const vigorousMET = 6.0 // the floor of vigorous intensity
+
+// ScoringModel turns some days (normally the last seven) into a Summary
+type ScoringModel interface {
+	Name() string
+	Summarize(claims JWTClaims, days []DayLog) Summary
+}
+
+// scoringModels is every selectable model, the first is the default
+var scoringModels = []struct {
+	Name  string
+	Label string
+	New   func(activityTypes []ActivityType) ScoringModel
+}{
+	{
+		Name:  effortScoringModelName,
+		Label: "Effort",
+		New:   func([]ActivityType) ScoringModel { return effortScoringModel{} },
+	},
+	{
+		Name:  metMinutesScoringModelName,
+		Label: "MET-minutes",
+		New:   func(t []ActivityType) ScoringModel { return metMinutesScoringModel{activityTypes: t} },
+	},
+}
+
+// loadScoring reads what's needed to summarize a user's days outside of a session, like in background jobs
+// only the days from and to are read, either can be zero, see readDaysBetween
+func loadScoring(ctx context.Context, getFileHandler fileHandlerFunc, userInfo UserInfo, from, to time.Time) (ScoringModel, []DayLog, error) {
+	days, err := readDaysBetween(ctx, getFileHandler, userInfo.Username, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	activityTypes, err := loadActivityTypes(ctx, getFileHandler, userInfo.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+	return scoringModelFor(userInfo.ScoringModel, activityTypes), days, nil
+}
+
+// scoringModelFor returns the named model, falling back to the default
+func scoringModelFor(name string, activityTypes []ActivityType) ScoringModel {
+	for _, m := range scoringModels {
+		if m.Name == name {
+			return m.New(activityTypes)
+		}
+	}
+	return scoringModels[0].New(activityTypes)
+}
+
+func isScoringModel(name string) bool {
+	for _, m := range scoringModels {
+		if m.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// effortScoringModel is the original model, time in each effort bucket weighted towards 2.5h/week of moderate
+type effortScoringModel struct{}
+
+func (effortScoringModel) Name() string {
+	return effortScoringModelName
+}
+
+func (effortScoringModel) Summarize(claims JWTClaims, days []DayLog) Summary {
+	return calcSummary(claims, days)
+}
+
+// metMinutesScoringModel scores the MET-minutes of each entry against the WHO guideline
+type metMinutesScoringModel struct {
+	activityTypes []ActivityType
+}
+
+func (metMinutesScoringModel) Name() string {
+	return metMinutesScoringModelName
+}
+
+func (m metMinutesScoringModel) Summarize(claims JWTClaims, days []DayLog) Summary {
+	// start with the effort model for the heart rates and intensity sums
+	s := calcSummary(claims, days)
+	s.Model = metMinutesScoringModelName
+
+	var low, moderate, high float64
+	for _, d := range days {
+		for _, e := range d.Entries {
+			metMinutes := m.entryMET(e) * e.Duration.Minutes()
+			if e.Effort >= highFloorPercentage {
+				high += metMinutes
+			} else if e.Effort >= moderateFloorPercentage {
+				moderate += metMinutes
+			} else {
+				low += metMinutes
+			}
+		}
+	}
+
+	goal := metMinutesGoalPerWeek * float64(len(days)) / 7
+	s.METMinutes = low + moderate + high
+	s.METMinutesGoal = goal
+
+	s.LowIntensityScore = 100 * low / goal
+	s.ModerateIntensityScore = 100 * moderate / goal
+	s.HighIntensityScore = 100 * high / goal
+	s.ComboScore = 100 * s.METMinutes / goal
+	s.BonusLevel = 100 * metMinutesBonusPerWeek / metMinutesGoalPerWeek
+
+	remainingMinutes := (goal - s.METMinutes) / moderateMET
+	s.RemainingModerateTime = time.Duration(float64(time.Minute) * max(0, math.Floor(remainingMinutes)))
+
+	return s
+}
+
+// entryMET uses the activity type's MET if known, otherwise maps the effort onto METs
+func (m metMinutesScoringModel) entryMET(e DayEntry) float64 {
+	if t, ok := findActivityType(m.activityTypes, e.Type); ok {
+		return t.MET
+	}
+	return metFromEffort(e.Effort)
+}
+
+// metFromEffort maps the moderate floor to 3 MET and the high floor to 6 MET
+func metFromEffort(effort float32) float64 {
+	slope := (vigorousMET - moderateMET) / (highFloorPercentage - moderateFloorPercentage)
+	return max(1.5, moderateMET+slope*(float64(effort)-moderateFloorPercentage))
+}
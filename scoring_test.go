@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_metMinutesScoringModel(t *testing.T) {
+	claims := JWTClaims{DateOfBirth: time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)}
+	model := scoringModelFor(metMinutesScoringModelName, []ActivityType{
+		{Name: "run", MET: 10, DefaultEffort: 0.8},
+	})
+
+	week := make([]DayLog, 7)
+	week[0].Entries = []DayEntry{
+		{Duration: 30 * time.Minute, Effort: 0.8, Type: "run"},   // 300 MET-min from the type
+		{Duration: 20 * time.Minute, Effort: 0.5, Type: "swing"}, // unknown type, 3 MET from effort = 60
+	}
+
+	s := model.Summarize(claims, week)
+
+	if s.Model != metMinutesScoringModelName {
+		t.Errorf("Model = %s", s.Model)
+	}
+	if s.METMinutes != 360 {
+		t.Errorf("METMinutes = %f, want 360", s.METMinutes)
+	}
+	if math.Abs(s.ComboScore-72) > 0.001 {
+		t.Errorf("ComboScore = %f, want 72", s.ComboScore)
+	}
+	if math.Abs(s.HighIntensityScore-60) > 0.001 || math.Abs(s.ModerateIntensityScore-12) > 0.001 {
+		t.Errorf("intensity scores = %f/%f, want 60/12", s.HighIntensityScore, s.ModerateIntensityScore)
+	}
+	if s.RemainingModerateTime != 46*time.Minute {
+		t.Errorf("RemainingModerateTime = %s, want 46m", s.RemainingModerateTime)
+	}
+	if s.BonusLevel != 200 {
+		t.Errorf("BonusLevel = %f, want 200", s.BonusLevel)
+	}
+}
+
+func Test_metFromEffort(t *testing.T) {
+	tests := []struct {
+		effort float32
+		want   float64
+	}{
+		{effort: 0, want: 1.5},
+		{effort: moderateFloorPercentage, want: moderateMET},
+		{effort: highFloorPercentage, want: vigorousMET},
+		{effort: 1, want: 10.5},
+	}
+	for _, tt := range tests {
+		if got := metFromEffort(tt.effort); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("metFromEffort(%f) = %f, want %f", tt.effort, got, tt.want)
+		}
+	}
+}
+
+func Test_scoringModelFor_default(t *testing.T) {
+	if m := scoringModelFor("", nil); m.Name() != effortScoringModelName {
+		t.Errorf("default model = %s", m.Name())
+	}
+	if m := scoringModelFor("nonsense", nil); m.Name() != effortScoringModelName {
+		t.Errorf("unknown model = %s", m.Name())
+	}
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the segmented layout keeps a user's entries in small files under entries/, rather than the one data file
+//
+//	entries/2024-05.csv                                a month's segment
+//	entries/2024-06/1718608500000000000-3f2a9c01.csv  an append, one file for each write
+//
+// appends never read or replace the history, so they cost the same however long it is and can't overwrite each other
+// compaction merges a month's appends into its segment, which starts with the appends it covers
+//
+//	#activity-tracker-segment 1718608500000000000-3f2a9c01.csv ...
+//	#activity-tracker-data v2
+//	date,duration,effort,description,type,start
+//
+// so readers skip any that are still there, they're deleted after the segment is written
+// the data file of a segmented user is just segmentedMarker, older versions refuse it rather than seeing no entries
+type dataLayout string
+
+const (
+	layoutFile      dataLayout = "file"
+	layoutSegmented dataLayout = "segmented"
+)
+
+const (
+	segmentedMarker = "#activity-tracker-data segmented"
+	segmentPrefix   = "#activity-tracker-segment"
+	entriesDir      = "entries"
+	monthFormat     = "2006-01"
+	undatedMonth    = "undated" // rows without a valid date, only fsck sees them
+	monthsRead      = 8         // months fetched at once
+	maxRangeMonths  = 24        // past this a range lists everything once rather than each month
+)
+
+// errSegmentedLayout is from reading the data file of a user in the segmented layout
+var errSegmentedLayout = errors.New("entries are in the segmented layout")
+
+func isSegmentedData(raw []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte(segmentedMarker))
+}
+
+// entriesMonth is what's stored for a month, the names are under entries/
+type entriesMonth struct {
+	name    string   // 2024-06, or undatedMonth
+	segment bool     // entries/<name>.csv might exist
+	appends []string // entries/<name>/<file>, oldest first
+	version string   // of the segment when it was read, see rewriteMonths
+}
+
+// readSegmented reads the rows in the months from and to are in, either can be zero for no limit
+func readSegmented(ctx context.Context, getFileHandler fileHandlerFunc, username string, from, to time.Time) ([][]string, error) {
+	months, err := listMonths(ctx, getFileHandler, username, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([][][]string, len(months))
+	errs := make([]error, len(months))
+	sem := make(chan struct{}, monthsRead)
+	var wg sync.WaitGroup
+	for i := range months {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = readMonth(ctx, getFileHandler, username, &months[i])
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return slices.Concat(results...), nil
+}
+
+// listMonths finds the months with entries between from and to, listing each one if it's a short range
+func listMonths(ctx context.Context, getFileHandler fileHandlerFunc, username string, from, to time.Time) ([]entriesMonth, error) {
+	if !from.IsZero() && !to.IsZero() && !to.Before(from) {
+		first := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if first.AddDate(0, maxRangeMonths, 0).After(to) {
+			var months []entriesMonth
+			for m := first; !m.After(to); m = m.AddDate(0, 1, 0) {
+				month := entriesMonth{name: m.Format(monthFormat), segment: true}
+				err := month.listAppends(ctx, getFileHandler, username)
+				if err != nil {
+					return nil, err
+				}
+				months = append(months, month)
+			}
+			return months, nil
+		}
+	}
+
+	names, err := listFiles(ctx, getFileHandler, username, entriesDir)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*entriesMonth)
+	get := func(name string) *entriesMonth {
+		if byName[name] == nil {
+			byName[name] = &entriesMonth{name: name}
+		}
+		return byName[name]
+	}
+	for _, n := range names {
+		if month, file, ok := strings.Cut(n, "/"); ok {
+			m := get(month)
+			m.appends = append(m.appends, file)
+		} else if month, ok := strings.CutSuffix(n, ".csv"); ok {
+			get(month).segment = true
+		}
+	}
+
+	var months []entriesMonth
+	for name, m := range byName {
+		if monthWithin(name, from, to) {
+			slices.Sort(m.appends)
+			months = append(months, *m)
+		}
+	}
+	slices.SortFunc(months, func(a, b entriesMonth) int { return strings.Compare(a.name, b.name) })
+	return months, nil
+}
+
+// monthWithin is whether the month overlaps from and to, undated rows are only in unlimited reads
+func monthWithin(name string, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	m, err := time.Parse(monthFormat, name)
+	if err != nil {
+		return false
+	}
+	if !from.IsZero() && !m.AddDate(0, 1, 0).After(from) {
+		return false
+	}
+	return to.IsZero() || !m.After(to)
+}
+
+func (m *entriesMonth) listAppends(ctx context.Context, getFileHandler fileHandlerFunc, username string) error {
+	appends, err := listFiles(ctx, getFileHandler, username, entriesDir+"/"+m.name)
+	if err != nil {
+		return err
+	}
+	slices.Sort(appends)
+	m.appends = appends
+	return nil
+}
+
+// readMonth reads the segment and then the appends it doesn't cover
+// an append that's gone was compacted after the month was listed, so it's read again
+func readMonth(ctx context.Context, getFileHandler fileHandlerFunc, username string, m *entriesMonth) ([][]string, error) {
+	for attempt := 0; ; attempt++ {
+		records, complete, err := readMonthOnce(ctx, getFileHandler, username, m)
+		if err != nil || complete {
+			return records, err
+		}
+		if attempt == 2 {
+			return nil, fmt.Errorf("entries for %s kept changing while being read", m.name)
+		}
+		m.segment = true
+		err = m.listAppends(ctx, getFileHandler, username)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readMonthOnce(ctx context.Context, getFileHandler fileHandlerFunc, username string, m *entriesMonth) ([][]string, bool, error) {
+	var records [][]string
+	var covers []string
+	if m.segment {
+		var err error
+		covers, records, m.version, err = readSegmentFile(ctx, getFileHandler, username, segmentFileName(m.name))
+		if err != nil && !isNotExist(err) {
+			return nil, false, err
+		}
+	}
+
+	for _, a := range m.appends {
+		if slices.Contains(covers, a) {
+			continue
+		}
+		_, rows, _, err := readSegmentFile(ctx, getFileHandler, username, appendFileName(m.name, a))
+		if isNotExist(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		records = append(records, rows...)
+	}
+	return records, true, nil
+}
+
+// readSegmentFile reads a segment, or an append which covers nothing
+// they're cached like the data file, and only downloaded and parsed again when they've changed
+// the version is from readIfChanged, empty if the storage doesn't have them
+func readSegmentFile(ctx context.Context, getFileHandler fileHandlerFunc, username, fileName string) ([]string, [][]string, string, error) {
+	f, err := getFileHandler(ctx, username, fileName)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer safeClose(ctx, f, "read segment")
+	vf, ok := f.(versionedFile)
+	if !ok {
+		raw, err := io.ReadAll(f)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		covers, records, err := decodeSegmentFile(ctx, fileName, raw)
+		return covers, records, "", err
+	}
+
+	id := vf.fileID()
+	cachedVersion, covers, records := daysCache.getRecords(id)
+	version, err := vf.readIfChanged(cachedVersion)
+	if errors.Is(err, errNotModified) {
+		dayCacheResults.WithLabelValues("hit").Inc()
+		return covers, records, version, nil
+	}
+	dayCacheResults.WithLabelValues("miss").Inc()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	covers, records, err = decodeSegmentFile(ctx, fileName, raw)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	daysCache.putRecords(id, version, covers, records)
+	return covers, records, version, nil
+}
+
+// segmentChanged is whether the month's segment isn't at the version it was read at any more
+// storages without versions can't tell, they only have monthLocks
+func segmentChanged(ctx context.Context, getFileHandler fileHandlerFunc, username string, m entriesMonth) (bool, error) {
+	f, err := getFileHandler(ctx, username, segmentFileName(m.name))
+	if err != nil {
+		return false, err
+	}
+	defer safeClose(ctx, f, "check segment")
+	vf, ok := f.(versionedFile)
+	if !ok {
+		return false, nil
+	}
+	_, err = vf.readIfChanged(m.version)
+	if errors.Is(err, errNotModified) || (m.version == "" && isNotExist(err)) {
+		return false, nil
+	}
+	if err != nil && !isNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func decodeSegmentFile(ctx context.Context, fileName string, raw []byte) ([]string, [][]string, error) {
+	covers, records, err := decodeSegment(ctx, raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %w", fileName, err)
+	}
+	return covers, records, nil
+}
+
+func segmentFileName(month string) string {
+	return entriesDir + "/" + month + ".csv"
+}
+
+func appendFileName(month, name string) string {
+	return entriesDir + "/" + month + "/" + name
+}
+
+// decodeSegment returns the appends the segment covers, and its rows
+func decodeSegment(ctx context.Context, raw []byte) ([]string, [][]string, error) {
+	var covers []string
+	if rest, ok := bytes.CutPrefix(raw, []byte(segmentPrefix)); ok {
+		line, body, _ := bytes.Cut(rest, []byte("\n"))
+		covers = strings.Fields(string(line))
+		raw = body
+	}
+	_, records, err := decodeTraced(ctx, raw)
+	return covers, records, err
+}
+
+func encodeSegment(w io.Writer, covers []string, records [][]string) error {
+	if len(covers) > 0 {
+		_, err := fmt.Fprintln(w, segmentPrefix, strings.Join(covers, " "))
+		if err != nil {
+			return err
+		}
+	}
+	return encodeData(w, records)
+}
+
+// groupByMonth splits the rows into the months they're stored in
+func groupByMonth(records [][]string) map[string][][]string {
+	months := make(map[string][][]string)
+	for _, r := range records {
+		month := undatedMonth
+		if len(r) > 0 {
+			if date, err := time.Parse(time.DateOnly, r[0]); err == nil {
+				month = date.Format(monthFormat)
+			}
+		}
+		months[month] = append(months[month], r)
+	}
+	return months
+}
+
+// appendSegmented adds the days' entries without reading anything, one append for each month they're in
+func appendSegmented(ctx context.Context, getFileHandler fileHandlerFunc, username string, days []DayLog) error {
+	for month, records := range groupByMonth(toCSVRecords(filterDays(days, time.Time{}, time.Time{}))) {
+		var b bytes.Buffer
+		err := encodeData(&b, records)
+		if err != nil {
+			return fmt.Errorf("failed to write entries: %w", err)
+		}
+		err = writeUserFile(ctx, getFileHandler, username, appendFileName(month, newAppendName(time.Now())), b.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newAppendName sorts by when it was written, the random part is for writes at the same time
+func newAppendName(now time.Time) string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("%019d-%s.csv", now.UnixNano(), hex.EncodeToString(b[:]))
+}
+
+// monthLocks is a mutex for each user's month, so edits and compaction in this process don't write over each other's segments
+var monthLocks sync.Map
+
+// rewriteMonths replaces the segments of the months with what edit returns, compacting their appends
+// edit is given every row in the months, and rows it returns in other months replace those months' segments
+// a nil edit only compacts, keeping the rows as they are
+// it holds the months' locks, and starts again if a segment changed anyway, from another process, before it's written
+func rewriteMonths(ctx context.Context, getFileHandler fileHandlerFunc, username string, months []string, edit func([][]string) ([][]string, error)) error {
+	slices.Sort(months)
+	months = slices.Compact(months)
+
+	// always in order, so two rewrites can't each hold a month the other is waiting for
+	for _, name := range months {
+		mu, _ := monthLocks.LoadOrStore(username+"/"+name, &sync.Mutex{})
+		mu.(*sync.Mutex).Lock()
+		defer mu.(*sync.Mutex).Unlock()
+	}
+
+	for attempt := 0; ; attempt++ {
+		done, err := rewriteMonthsOnce(ctx, getFileHandler, username, months, edit)
+		if err != nil || done {
+			return err
+		}
+		if attempt == 2 {
+			return fmt.Errorf("entries for %s kept changing while being rewritten", strings.Join(months, ", "))
+		}
+	}
+}
+
+func rewriteMonthsOnce(ctx context.Context, getFileHandler fileHandlerFunc, username string, months []string, edit func([][]string) ([][]string, error)) (bool, error) {
+	var records [][]string
+	read := make(map[string]entriesMonth)
+	for _, name := range months {
+		m := entriesMonth{name: name, segment: true}
+		err := m.listAppends(ctx, getFileHandler, username)
+		if err != nil {
+			return false, err
+		}
+		rows, err := readMonth(ctx, getFileHandler, username, &m)
+		if err != nil {
+			return false, err
+		}
+		records = append(records, rows...)
+		read[name] = m
+	}
+
+	if edit != nil {
+		var err error
+		records, err = edit(records)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for _, m := range read {
+		changed, err := segmentChanged(ctx, getFileHandler, username, m)
+		if err != nil || changed {
+			return false, err
+		}
+	}
+
+	grouped := groupByMonth(records)
+	for _, name := range months {
+		if _, ok := grouped[name]; !ok {
+			// emptied, the segment still covers its appends
+			grouped[name] = nil
+		}
+	}
+	for name, rows := range grouped {
+		var b bytes.Buffer
+		err := encodeSegment(&b, read[name].appends, rows)
+		if err != nil {
+			return false, fmt.Errorf("failed to write segment: %w", err)
+		}
+		err = writeUserFile(ctx, getFileHandler, username, segmentFileName(name), b.Bytes())
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// only once every segment is written, until then these are what has the entries
+	for name, m := range read {
+		for _, a := range m.appends {
+			err := removeUserFile(ctx, getFileHandler, username, appendFileName(name, a))
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// replaceSegmented rewrites every month with records, failing if any changed since before was read
+func replaceSegmented(ctx context.Context, getFileHandler fileHandlerFunc, username string, before, records [][]string) error {
+	months, err := listMonths(ctx, getFileHandler, username, time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, m := range months {
+		names = append(names, m.name)
+	}
+	return rewriteMonths(ctx, getFileHandler, username, names, func(current [][]string) ([][]string, error) {
+		if !slices.EqualFunc(current, before, slices.Equal[[]string]) {
+			return nil, errors.New("entries changed while they were being checked, try again")
+		}
+		return records, nil
+	})
+}
+
+// compactUser merges every month's appends into its segment, returning how many months had any
+// users in the file layout don't have any
+func compactUser(ctx context.Context, getFileHandler fileHandlerFunc, username string) (int, error) {
+	months, err := listMonths(ctx, getFileHandler, username, time.Time{}, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	compacted := 0
+	for _, m := range months {
+		if len(m.appends) == 0 {
+			continue
+		}
+		err = rewriteMonths(ctx, getFileHandler, username, []string{m.name}, nil)
+		if err != nil {
+			return compacted, fmt.Errorf("compacting %s: %w", m.name, err)
+		}
+		compacted++
+	}
+	return compacted, nil
+}
+
+// compactAll compacts every user, returning how many months were compacted
+func compactAll(ctx context.Context, storage Storage) (int, error) {
+	users, err := storage.ListUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, u := range users {
+		n, err := compactUser(ctx, storage.Open, u)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("compacting %s: %w", u, err)
+		}
+	}
+	return total, nil
+}
+
+// userLayout reads which layout the user's entries are in
+func userLayout(ctx context.Context, getFileHandler fileHandlerFunc, username string) (dataLayout, error) {
+	raw, err := readUserFile(ctx, getFileHandler, username, userDataFileName)
+	if err != nil && !isNotExist(err) {
+		return "", err
+	}
+	if isSegmentedData(raw) {
+		return layoutSegmented, nil
+	}
+	return layoutFile, nil
+}
+
+// setLayout moves the user's entries into the layout, returning false if they're already in it
+func setLayout(ctx context.Context, getFileHandler fileHandlerFunc, username string, layout dataLayout) (bool, error) {
+	records, err := readDataRecords(ctx, getFileHandler, username)
+	segmented := errors.Is(err, errSegmentedLayout)
+	if err != nil && !segmented {
+		return false, err
+	}
+
+	switch {
+	case layout == layoutSegmented && !segmented:
+		// anything left from being segmented before would be read twice
+		err = removeEntries(ctx, getFileHandler, username)
+		if err != nil {
+			return false, err
+		}
+		for month, rows := range groupByMonth(records) {
+			var b bytes.Buffer
+			err = encodeSegment(&b, nil, rows)
+			if err == nil {
+				err = writeUserFile(ctx, getFileHandler, username, segmentFileName(month), b.Bytes())
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		// last, until then the data file still has everything
+		return true, writeDataMarker(ctx, getFileHandler, username)
+	case layout == layoutFile && segmented:
+		records, err = readSegmented(ctx, getFileHandler, username, time.Time{}, time.Time{})
+		if err != nil {
+			return false, err
+		}
+		err = writeDataRecords(ctx, getFileHandler, username, records)
+		if err != nil {
+			return false, err
+		}
+		return true, removeEntries(ctx, getFileHandler, username)
+	}
+	return false, nil
+}
+
+func writeDataMarker(ctx context.Context, getFileHandler fileHandlerFunc, username string) error {
+	f, err := getFileHandler(ctx, username, userDataFileName)
+	if err != nil {
+		return err
+	}
+	if vf, ok := f.(versionedFile); ok {
+		daysCache.forget(vf.fileID())
+	}
+	_, err = io.WriteString(f, segmentedMarker+"\n")
+	if err != nil {
+		safeClose(ctx, f, "write data marker")
+		return fmt.Errorf("failed to write data: %w", err)
+	}
+	return f.Close()
+}
+
+func removeEntries(ctx context.Context, getFileHandler fileHandlerFunc, username string) error {
+	names, err := listFiles(ctx, getFileHandler, username, entriesDir)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		err = removeUserFile(ctx, getFileHandler, username, entriesDir+"/"+n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allDataRecords is every row of the user's entries in either layout, without migrating anything
+func allDataRecords(ctx context.Context, getFileHandler fileHandlerFunc, username string) ([][]string, error) {
+	_, records, err := migrateUserData(ctx, getFileHandler, username, true)
+	if errors.Is(err, errSegmentedLayout) {
+		return readSegmented(ctx, getFileHandler, username, time.Time{}, time.Time{})
+	}
+	return records, err
+}
+
+func listFiles(ctx context.Context, getFileHandler fileHandlerFunc, username, dir string) ([]string, error) {
+	f, err := getFileHandler(ctx, username, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer safeClose(ctx, f, "list files")
+	lf, ok := f.(listableFile)
+	if !ok {
+		return nil, errors.New("this storage can't list files, which the segmented layout needs")
+	}
+	return lf.list()
+}
+
+func readUserFile(ctx context.Context, getFileHandler fileHandlerFunc, username, fileName string) ([]byte, error) {
+	f, err := getFileHandler(ctx, username, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer safeClose(ctx, f, "read file")
+	return io.ReadAll(f)
+}
+
+func writeUserFile(ctx context.Context, getFileHandler fileHandlerFunc, username, fileName string, raw []byte) error {
+	f, err := getFileHandler(ctx, username, fileName)
+	if err != nil {
+		return err
+	}
+	if vf, ok := f.(versionedFile); ok {
+		daysCache.forget(vf.fileID())
+	}
+	_, err = f.Write(raw)
+	if err != nil {
+		safeClose(ctx, f, "write file")
+		return fmt.Errorf("failed to write %s: %w", fileName, err)
+	}
+	return f.Close()
+}
+
+func removeUserFile(ctx context.Context, getFileHandler fileHandlerFunc, username, fileName string) error {
+	f, err := getFileHandler(ctx, username, fileName)
+	if err != nil {
+		return err
+	}
+	defer safeClose(ctx, f, "remove file")
+	if vf, ok := f.(versionedFile); ok {
+		daysCache.forget(vf.fileID())
+	}
+	rf, ok := f.(removableFile)
+	if !ok {
+		return errors.New("this storage can't remove files, which the segmented layout needs")
+	}
+	return rf.remove()
+}
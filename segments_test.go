@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test_segmentedLayout runs the entry operations on a segmented user, against each backend
+func Test_segmentedLayout(t *testing.T) {
+	ctx := context.Background()
+	s3 := newFakeS3(t, "tracker")
+	backends := map[string]Storage{
+		"local": LocalStorage{Dir: t.TempDir()},
+		"s3":    s3.storage("data/"),
+	}
+	june17 := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	june18 := june17.AddDate(0, 0, 1)
+	july2 := time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC)
+
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = addEntry(ctx, storage.Open, "alice", june17, DayEntry{Duration: time.Hour, Effort: 0.5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			moved, err := setLayout(ctx, storage.Open, "alice", layoutSegmented)
+			if err != nil || !moved {
+				t.Fatalf("setLayout() = %v, %v", moved, err)
+			}
+			for _, d := range []time.Time{june18, july2, july2} {
+				err = addEntry(ctx, storage.Open, "alice", d, DayEntry{Duration: 30 * time.Minute, Effort: 0.8})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := entryFiles(t, storage); len(got) != 4 || !slices.Contains(got, "2024-06.csv") {
+				t.Errorf("entries = %v, want the June segment and three appends", got)
+			}
+
+			// a range only reads its months
+			before := s3.downloads()
+			july, err := readDaysBetween(ctx, storage.Open, "alice", july2, july2.AddDate(0, 0, 5))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dayEntryCounts(july); got != "2024-07-02:2" {
+				t.Errorf("readDaysBetween() = %s", got)
+			}
+			if name == "s3" && s3.downloads()-before != 3 {
+				t.Errorf("reading July downloaded %d files, want the data file's marker and its two appends", s3.downloads()-before)
+			}
+
+			days, err := readDays(ctx, storage.Open, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dayEntryCounts(days); got != "2024-07-02:2 2024-06-18:1 2024-06-17:1" {
+				t.Errorf("readDays() = %s", got)
+			}
+			// and the files are only downloaded again once they've changed
+			before = s3.downloads()
+			cached, err := readDays(ctx, storage.Open, "alice")
+			if err != nil || !reflect.DeepEqual(cached, days) {
+				t.Errorf("cached readDays() = %+v, %v\nwant %+v", cached, err, days)
+			}
+			if name == "s3" && s3.downloads() != before {
+				t.Errorf("reading again downloaded %d files", s3.downloads()-before)
+			}
+
+			months, err := compactUser(ctx, storage.Open, "alice")
+			if err != nil || months != 2 {
+				t.Fatalf("compactUser() = %d, %v", months, err)
+			}
+			if got := entryFiles(t, storage); !slices.Equal(got, []string{"2024-06.csv", "2024-07.csv"}) {
+				t.Errorf("entries after compacting = %v", got)
+			}
+			compacted, err := readDays(ctx, storage.Open, "alice")
+			if err != nil || !reflect.DeepEqual(compacted, days) {
+				t.Errorf("readDays() after compacting = %+v, %v\nwant %+v", compacted, err, days)
+			}
+
+			_, err = deleteEntry(ctx, storage.Open, "alice", june18, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = updateEntry(ctx, storage.Open, "alice", july2, 1, june18, DayEntry{Duration: 20 * time.Minute, Effort: 0.3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			added, skipped, err := importDays(ctx, storage.Open, "alice", []DayLog{
+				{Date: june17, Entries: []DayEntry{{Duration: time.Hour, Effort: 0.5}}},
+				{Date: july2.AddDate(0, 0, 1), Entries: []DayEntry{{Duration: time.Hour, Effort: 0.5}}},
+			})
+			if err != nil || added != 1 || skipped != 1 {
+				t.Errorf("importDays() = %d, %d, %v", added, skipped, err)
+			}
+			days, err = readDays(ctx, storage.Open, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dayEntryCounts(days); got != "2024-07-03:1 2024-07-02:1 2024-06-18:1 2024-06-17:1" {
+				t.Errorf("readDays() after editing = %s", got)
+			}
+
+			moved, err = setLayout(ctx, storage.Open, "alice", layoutFile)
+			if err != nil || !moved {
+				t.Fatalf("setLayout() back = %v, %v", moved, err)
+			}
+			if got := entryFiles(t, storage); len(got) != 0 {
+				t.Errorf("entries left after moving back = %v", got)
+			}
+			inFile, err := readDays(ctx, storage.Open, "alice")
+			if err != nil || !reflect.DeepEqual(inFile, days) {
+				t.Errorf("readDays() in the file layout = %+v, %v\nwant %+v", inFile, err, days)
+			}
+		})
+	}
+}
+
+// Test_readSegmented_compactedAppend is a reader seeing an append that's been compacted but not deleted yet
+func Test_readSegmented_compactedAppend(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	day := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	newSegmentedUser(t, storage, "alice")
+	err := addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: time.Hour, Effort: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appended := entryFiles(t, storage)[0]
+	raw, err := readUserFile(ctx, storage.Open, "alice", entriesDir+"/"+appended)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = compactUser(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeUserFile(ctx, storage.Open, "alice", entriesDir+"/"+appended, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dayEntryCounts(days); got != "2024-06-17:1" {
+		t.Errorf("readDays() = %s, want the entry once", got)
+	}
+}
+
+// Test_addEntry_concurrentSegmented is what lost entries in the file layout, each write replacing the others
+func Test_addEntry_concurrentSegmented(t *testing.T) {
+	ctx := context.Background()
+	storage := newFakeS3(t, "tracker").storage("data/")
+	day := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	newSegmentedUser(t, storage, "alice")
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: time.Duration(i+1) * time.Minute, Effort: 0.5})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dayEntryCounts(days); got != "2024-06-17:20" {
+		t.Errorf("readDays() = %s, want all 20 entries", got)
+	}
+}
+
+// Test_updateEntry_concurrentCompaction has edits and compaction rewriting the same month at once
+func Test_updateEntry_concurrentCompaction(t *testing.T) {
+	ctx := context.Background()
+	storage := newFakeS3(t, "tracker").storage("data/")
+	day := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	newSegmentedUser(t, storage, "alice")
+	for i := range 10 {
+		err := addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: time.Duration(i+1) * time.Minute, Effort: 0.5})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			entry := DayEntry{Duration: time.Duration(i+1) * time.Minute, Effort: 0.5, Description: "updated"}
+			err := updateEntry(ctx, storage.Open, "alice", day, i, day, entry)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := compactUser(ctx, storage.Open, "alice")
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dayEntryCounts(days); got != "2024-06-17:10" {
+		t.Fatalf("readDays() = %s, want all 10 entries", got)
+	}
+	for i, e := range days[0].Entries {
+		if e.Description != "updated" {
+			t.Errorf("entry %d = %+v, the update was lost", i, e)
+		}
+	}
+}
+
+func Test_restoreUser_segmented(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	backups := backupStore{root: LocalStorage{Dir: t.TempDir()}}
+	day := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	newSegmentedUser(t, storage, "alice")
+	err := addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: time.Hour, Effort: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotAt := time.Date(2024, 6, 18, 3, 0, 0, 0, time.UTC)
+	_, _, err = backupAll(ctx, storage, backups, snapshotAt, defaultBackupRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: 30 * time.Minute, Effort: 0.8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := planRestore(ctx, storage, backups, "alice", snapshotAt, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Removed) != 1 || len(plan.Added) != 0 {
+		t.Errorf("planRestore() removed %v, added %v", plan.Removed, plan.Added)
+	}
+	err = restoreUser(ctx, storage, backups, plan, snapshotAt.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dayEntryCounts(days); got != "2024-06-17:1" {
+		t.Errorf("readDays() after restoring = %s", got)
+	}
+}
+
+// Benchmark_addEntry is adding to two years of entries, which the file layout downloads and uploads each time
+func Benchmark_addEntry(b *testing.B) {
+	ctx := context.Background()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var days []DayLog
+	for i := range 730 {
+		days = append(days, DayLog{
+			Date:    start.AddDate(0, 0, i),
+			Entries: []DayEntry{{Duration: 45 * time.Minute, Effort: 0.6, Description: fmt.Sprintf("run %d", i)}},
+		})
+	}
+
+	for _, layout := range []dataLayout{layoutFile, layoutSegmented} {
+		b.Run(string(layout), func(b *testing.B) {
+			storage := newFakeS3(b, "tracker").storage("data/")
+			err := writeDays(ctx, storage.Open, "alice", days)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = setLayout(ctx, storage.Open, "alice", layout)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for range b.N {
+				err := addEntry(ctx, storage.Open, "alice", start.AddDate(0, 0, 800), DayEntry{Duration: time.Hour, Effort: 0.5})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func newSegmentedUser(t *testing.T, storage Storage, username string) {
+	t.Helper()
+	ctx := context.Background()
+	err := createUser(ctx, storage.Open, UserInfo{Username: username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = setLayout(ctx, storage.Open, username, layoutSegmented)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// entryFiles lists alice's entries directory
+func entryFiles(t *testing.T, storage Storage) []string {
+	t.Helper()
+	names, err := listFiles(context.Background(), storage.Open, "alice", entriesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// dayEntryCounts is each day and how many entries it has, in the order given
+func dayEntryCounts(days []DayLog) string {
+	var counts []string
+	for _, d := range days {
+		counts = append(counts, fmt.Sprintf("%s:%d", d.Date.Format(time.DateOnly), len(d.Entries)))
+	}
+	return strings.Join(counts, " ")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serveOptions are the serve section of the Config, and the serve command's flags
+type serveOptions struct {
+	Addr            string        `yaml:"addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	TLSCert         string        `yaml:"tls_cert"`
+	TLSKey          string        `yaml:"tls_key"`
+	SelfSigned      bool          `yaml:"self_signed"`
+}
+
+// register adds the flags, defaulting to what's already set from the config
+func (o *serveOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Addr, "addr", o.Addr, "address and port to listen on")
+	fs.DurationVar(&o.ReadTimeout, "read-timeout", o.ReadTimeout, "limit on reading a request, headers and body")
+	fs.DurationVar(&o.WriteTimeout, "write-timeout", o.WriteTimeout, "limit on handling a request and writing the response")
+	fs.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", o.ShutdownTimeout, "how long to wait for requests to finish on SIGTERM or ctrl-c")
+	fs.StringVar(&o.TLSCert, "tls-cert", o.TLSCert, "serve https with this certificate file, needs --tls-key")
+	fs.StringVar(&o.TLSKey, "tls-key", o.TLSKey, "the key file for --tls-cert")
+	fs.BoolVar(&o.SelfSigned, "self-signed", o.SelfSigned, "serve https with a certificate for localhost made at startup, for development")
+}
+
+func (o serveOptions) validate() error {
+	if (o.TLSCert == "") != (o.TLSKey == "") {
+		return errors.New("--tls-cert and --tls-key go together")
+	}
+	if o.SelfSigned && o.TLSCert != "" {
+		return errors.New("--self-signed can't be used with --tls-cert")
+	}
+	return nil
+}
+
+// runServer serves until ctx is done, then stops taking requests and waits for the ones running to finish
+// requests don't get ctx, so a write isn't cancelled part way through by the shutdown
+func runServer(ctx context.Context, ln net.Listener, handler http.Handler, o serveOptions) error {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: o.ReadTimeout,
+		ReadTimeout:       o.ReadTimeout,
+		WriteTimeout:      o.WriteTimeout,
+	}
+	if o.SelfSigned {
+		cert, err := selfSignedCert(time.Now())
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if o.SelfSigned || o.TLSCert != "" {
+			errc <- srv.ServeTLS(ln, o.TLSCert, o.TLSKey)
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("serving: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, waiting for requests to finish", "timeout", o.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), o.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		_ = srv.Close()
+		return fmt.Errorf("shutting down: %w", err)
+	}
+	return nil
+}
+
+// selfSignedCert is only trusted after clicking through the browser's warning
+func selfSignedCert(now time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating serial: %w", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"activity-tracker development"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(0, 0, 30),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating certificate: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_runServer(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		// still able to use the request context, it isn't the server's
+		if r.Context().Err() != nil {
+			t.Errorf("request cancelled: %v", r.Context().Err())
+		}
+		_, _ = io.WriteString(w, "written")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- runServer(ctx, ln, handler, serveOptions{ShutdownTimeout: 5 * time.Second})
+	}()
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer safeClose(ctx, res.Body, "response")
+		body, err := io.ReadAll(res.Body)
+		responses <- response{string(body), err}
+	}()
+
+	// SIGTERM while the request is in flight
+	<-started
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("runServer() = %v before the request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	r := <-responses
+	if r.err != nil || r.body != "written" {
+		t.Errorf("in flight request got %q, %v", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("runServer() = %v", err)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("still listening after shutdown")
+	}
+}
+
+func Test_runServer_selfSigned(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.ServerName)
+	})
+	go func() {
+		_ = runServer(ctx, ln, handler, serveOptions{SelfSigned: true, ShutdownTimeout: time.Second})
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	res, err := client.Get("https://localhost:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(ctx, res.Body, "response")
+	if cert := res.TLS.PeerCertificates[0]; cert.VerifyHostname("localhost") != nil {
+		t.Errorf("certificate for %v, want localhost", cert.DNSNames)
+	}
+}
+
+func Test_serveOptions_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    serveOptions
+		wantErr bool
+	}{
+		{name: "http", opts: serveOptions{}},
+		{name: "cert and key", opts: serveOptions{TLSCert: "cert.pem", TLSKey: "key.pem"}},
+		{name: "self signed", opts: serveOptions{SelfSigned: true}},
+		{name: "cert without key", opts: serveOptions{TLSCert: "cert.pem"}, wantErr: true},
+		{name: "both", opts: serveOptions{TLSCert: "cert.pem", TLSKey: "key.pem", SelfSigned: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
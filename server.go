@@ -0,0 +1,499 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newServer sets up all the routes against whichever storage is configured
+func newServer(storage Storage, cfg Config) *echo.Echo {
+	getFileHandler := storage.Open
+	jwtSecret := []byte(cfg.JWTSecret)
+
+	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler
+	e.Use(otelecho.Middleware("activity-tracker"))
+	e.Use(Recover())
+	e.Use(RequestLogger())
+
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Path() {
+			case "/login", healthRoute, readyRoute, versionRoute:
+				return next(c)
+			case feedRoute, unsubscribeRoute, metricsRoute:
+				// the feed, unsubscribe links and metrics are authenticated by their own tokens
+				return next(c)
+			}
+			s, err := c.Cookie("session")
+			if err == nil {
+				claims, jwtErr := parseJWT(jwtSecret, s.Value)
+				if jwtErr == nil {
+					c.Set(jwtClaimsKey, claims)
+					c.SetRequest(c.Request().WithContext(withLogAttrs(c.Request().Context(), "user", claims.User)))
+					return next(c)
+				}
+				err = jwtErr
+			}
+			loggerFrom(c.Request().Context()).Info("301 for user", "err", err.Error())
+			switch {
+			case wantsJSON(c):
+				return unauthorizedError("log in first")
+			case isHtmxRequest(c):
+				// a redirect would be followed and swapped into the page
+				c.Response().Header().Set("HX-Redirect", "/login")
+				return c.NoContent(http.StatusUnauthorized)
+			}
+			return c.Redirect(http.StatusFound, "/login")
+		}
+	})
+
+	addHealthRoutes(e, storage, cfg)
+
+	e.GET("/login", func(c echo.Context) error {
+		return render(c, page(loginForm()))
+	})
+
+	e.POST("/login", func(c echo.Context) error {
+		var params struct {
+			Username string `form:"username"`
+			Password string `form:"password"`
+		}
+		// todo add validation
+		if err := c.Bind(&params); err != nil {
+			return err
+		}
+		userInfo, err := readUserInfo(c.Request().Context(), getFileHandler, params.Username)
+		if isNotExist(err) {
+			return unauthorizedError("wrong username or password")
+		}
+		if err != nil {
+			loggerFrom(c.Request().Context()).Warn("failed reading user info", "user", params.Username, "err", err)
+			return err
+		}
+
+		start := time.Now()
+		err = bcrypt.CompareHashAndPassword([]byte(userInfo.Password), []byte(params.Password))
+		loginDuration.Observe(time.Since(start).Seconds())
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return unauthorizedError("wrong username or password")
+		}
+		if err != nil {
+			loggerFrom(c.Request().Context()).Warn("failed comparing password", "user", params.Username, "err", err)
+			return unauthorizedError("wrong username or password")
+		}
+
+		err = setSessionCookie(c, jwtSecret, userInfo)
+		if err != nil {
+			return err
+		}
+		return c.Redirect(http.StatusFound, "/")
+	})
+
+	e.GET("", func(c echo.Context) error {
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		v, err := loadTrackerView(c.Request().Context(), getFileHandler, claims, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return render(c, page(mainContent(
+			summarySection(v.Summary, false),
+			achievementsSection(v.Achievements, false),
+			tracker(v.Days, v.Summary),
+		)))
+	})
+
+	e.POST("/entries", func(c echo.Context) error {
+
+		var form entryForm
+		if err := c.Bind(&form); err != nil {
+			return validationError("couldn't read the form")
+		}
+
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		date, entry, ok := form.parse()
+		if !ok {
+			// re-render the modal with the errors inline
+			activityTypes, err := loadActivityTypes(c.Request().Context(), getFileHandler, claims.User)
+			if err != nil {
+				return err
+			}
+			return render(c, addLogModal(form, activityTypes))
+		}
+
+		err := addEntry(c.Request().Context(), getFileHandler, claims.User, date, entry)
+		if err != nil {
+			return err
+		}
+		sendEntryWebhooks(c.Request().Context(), getFileHandler, claims.User, webhookEntryCreated, date, entry, nil, time.Now())
+
+		v, err := loadTrackerView(c.Request().Context(), getFileHandler, claims, time.Now())
+		if err != nil {
+			return err
+		}
+
+		// the modal is replaced with nothing, everything else is swapped out-of-band
+		oob := []templ.Component{
+			summarySection(v.Summary, true),
+			achievementsSection(v.Achievements, true),
+		}
+		if d, ok := v.day(date); ok {
+			oob = append(oob, dayView(d, true))
+		}
+		return render(c, fragments(oob...))
+	})
+
+	e.GET("/add-entry-modal", func(c echo.Context) error {
+		var params struct {
+			Date string `query:"date"`
+		}
+
+		if err := c.Bind(&params); err != nil {
+			return validationError("couldn't read the form")
+		}
+
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		activityTypes, err := loadActivityTypes(c.Request().Context(), getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		return render(c, addLogModal(newEntryForm(params.Date), activityTypes))
+	})
+
+	e.GET("/activity-types-modal", func(c echo.Context) error {
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		activityTypes, err := loadActivityTypes(c.Request().Context(), getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		return render(c, activityTypesModal(activityTypes, ""))
+	})
+
+	e.POST("/activity-types", func(c echo.Context) error {
+		var params struct {
+			Name          string  `form:"name"`
+			MET           float64 `form:"met"`
+			DefaultEffort float32 `form:"effort"`
+		}
+		if err := c.Bind(&params); err != nil {
+			return validationError("couldn't read the form")
+		}
+
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		ctx := c.Request().Context()
+		addErr := addUserActivityType(ctx, getFileHandler, claims.User, ActivityType{
+			Name:          params.Name,
+			MET:           params.MET,
+			DefaultEffort: params.DefaultEffort,
+		})
+
+		activityTypes, err := loadActivityTypes(ctx, getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		errMsg := ""
+		if addErr != nil {
+			errMsg = addErr.Error()
+		}
+		return render(c, activityTypesModal(activityTypes, errMsg))
+	})
+
+	e.GET("/export", func(c echo.Context) error {
+		var params struct {
+			Format string `query:"format"`
+			From   string `query:"from"`
+			To     string `query:"to"`
+		}
+		if err := c.Bind(&params); err != nil {
+			return validationError("couldn't read the form")
+		}
+		opts, err := parseExportOptions(params.Format, params.From, params.To)
+		if err != nil {
+			return validationError("%s", err)
+		}
+
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		c.Response().Header().Set(echo.HeaderContentType, opts.ContentType())
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", opts.FileName(claims.User)))
+		return exportUserData(c.Request().Context(), getFileHandler, claims.User, opts, c.Response())
+	})
+
+	e.GET(feedRoute, func(c echo.Context) error {
+		username := c.Param("user")
+		token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+		ctx := c.Request().Context()
+		userInfo, err := readUserInfo(ctx, getFileHandler, username)
+		if err != nil || !validFeedToken(userInfo, token) {
+			// same response whether the user doesn't exist or the token is wrong
+			return notFoundError("no such feed")
+		}
+
+		days, err := readDays(ctx, getFileHandler, username)
+		if err != nil {
+			return err
+		}
+		activityTypes, err := loadActivityTypes(ctx, getFileHandler, username)
+		if err != nil {
+			return err
+		}
+
+		c.Response().Header().Set(echo.HeaderContentType, exportContentTypes[exportFormatICS])
+		model := scoringModelFor(userInfo.ScoringModel, activityTypes)
+		return writeFeed(c.Response(), model, userInfo, days, time.Now())
+	})
+
+	e.GET("/feed-modal", func(c echo.Context) error {
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		userInfo, err := readUserInfo(c.Request().Context(), getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		return render(c, feedModal(feedURL(c.Request().Host, userInfo)))
+	})
+
+	// regenerates the feed token, the old url stops working
+	e.POST("/feed-token", func(c echo.Context) error {
+		token, err := newSecretToken()
+		if err != nil {
+			return err
+		}
+		return setFeedToken(c, getFileHandler, token)
+	})
+
+	e.DELETE("/feed-token", func(c echo.Context) error {
+		return setFeedToken(c, getFileHandler, "")
+	})
+
+	e.GET("/notifications-modal", func(c echo.Context) error {
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		userInfo, err := readUserInfo(c.Request().Context(), getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		return render(c, notificationsModal(newNotificationsForm(userInfo.Notifications), "", ""))
+	})
+
+	e.POST("/notifications", func(c echo.Context) error {
+		var form notificationsForm
+		if err := c.Bind(&form); err != nil {
+			return validationError("couldn't read the form")
+		}
+
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		ctx := c.Request().Context()
+		userInfo, err := readUserInfo(ctx, getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		settings, err := form.apply(userInfo.Notifications)
+		if err != nil {
+			return render(c, notificationsModal(form, "", err.Error()))
+		}
+		userInfo.Notifications = settings
+		err = writeUserInfo(ctx, getFileHandler, userInfo)
+		if err != nil {
+			return err
+		}
+		return render(c, notificationsModal(newNotificationsForm(settings), "Saved", ""))
+	})
+
+	// the link in emails only asks, mail scanners follow links
+	e.GET(unsubscribeRoute, func(c echo.Context) error {
+		_, kind, ok := unsubscribeRequest(c, getFileHandler)
+		if !ok {
+			return notFoundError("this unsubscribe link isn't valid any more")
+		}
+		return render(c, page(unsubscribeContent(kind, false)))
+	})
+
+	// from the page, or straight from mail clients supporting List-Unsubscribe-Post
+	e.POST(unsubscribeRoute, func(c echo.Context) error {
+		userInfo, kind, ok := unsubscribeRequest(c, getFileHandler)
+		if !ok {
+			return notFoundError("this unsubscribe link isn't valid any more")
+		}
+		userInfo.Notifications = userInfo.Notifications.Unsubscribe(kind)
+		err := writeUserInfo(c.Request().Context(), getFileHandler, userInfo)
+		if err != nil {
+			return err
+		}
+		return render(c, page(unsubscribeContent(kind, true)))
+	})
+
+	e.GET("/webhooks-modal", func(c echo.Context) error {
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		config, err := readWebhooks(c.Request().Context(), getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		return render(c, webhooksModal(config, ""))
+	})
+
+	e.POST("/webhooks", func(c echo.Context) error {
+		var params struct {
+			URL    string         `form:"url"`
+			Events []webhookEvent `form:"events"`
+		}
+		if err := c.Bind(&params); err != nil {
+			return validationError("couldn't read the form")
+		}
+
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		ctx := c.Request().Context()
+		_, addErr := addWebhook(ctx, getFileHandler, claims.User, params.URL, params.Events, time.Now())
+
+		config, err := readWebhooks(ctx, getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		errMsg := ""
+		if addErr != nil {
+			errMsg = addErr.Error()
+		}
+		return render(c, webhooksModal(config, errMsg))
+	})
+
+	e.DELETE("/webhooks/:id", func(c echo.Context) error {
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		ctx := c.Request().Context()
+		err := deleteWebhook(ctx, getFileHandler, claims.User, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		config, err := readWebhooks(ctx, getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		return render(c, webhooksModal(config, ""))
+	})
+
+	e.POST("/scoring-model", func(c echo.Context) error {
+		var params struct {
+			Model string `form:"model"`
+		}
+		if err := c.Bind(&params); err != nil {
+			return validationError("couldn't read the form")
+		}
+		if !isScoringModel(params.Model) {
+			return validationError("unknown scoring model %q", params.Model)
+		}
+
+		claims := c.Get(jwtClaimsKey).(JWTClaims)
+		ctx := c.Request().Context()
+		userInfo, err := readUserInfo(ctx, getFileHandler, claims.User)
+		if err != nil {
+			return err
+		}
+		userInfo.ScoringModel = params.Model
+		err = writeUserInfo(ctx, getFileHandler, userInfo)
+		if err != nil {
+			return err
+		}
+
+		// the model is part of the claims, so the session needs reissuing
+		err = setSessionCookie(c, jwtSecret, userInfo)
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set("HX-Refresh", "true")
+		return c.NoContent(http.StatusOK)
+	})
+
+	e.POST("/logout", func(c echo.Context) error {
+		c.SetCookie(&http.Cookie{
+			Name:    "session",
+			Value:   "invalid",
+			Expires: time.Now().Add(-1 * time.Hour),
+		})
+		return c.Redirect(http.StatusFound, "/login")
+	})
+
+	admin := e.Group("/admin", requireAdmin(getFileHandler))
+
+	// checks every user's data
+	admin.GET("/fsck", func(c echo.Context) error {
+		reports, err := fsckAllUsers(c.Request().Context(), storage, time.Now(), nil)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, reports)
+	})
+
+	// repairs what it can and quarantines the rest
+	admin.POST("/fsck", func(c echo.Context) error {
+		reports, err := fsckAllUsers(c.Request().Context(), storage, time.Now(), func(_ string, issue fsckIssue) fsckAction {
+			return autoRepair(issue)
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, reports)
+	})
+
+	fsys, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+	fileHandler := http.FileServer(http.FS(fsys))
+	e.GET("/styles.css", echo.WrapHandler(fileHandler))
+
+	return e
+}
+
+// requireAdmin checks the user is still an admin, rather than trusting the session
+func requireAdmin(getFileHandler fileHandlerFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := c.Get(jwtClaimsKey).(JWTClaims)
+			userInfo, err := readUserInfo(c.Request().Context(), getFileHandler, claims.User)
+			if err != nil {
+				return err
+			}
+			if !userInfo.Admin {
+				return forbiddenError("only admins can do that")
+			}
+			return next(c)
+		}
+	}
+}
+
+// setFeedToken saves the user's feed token and renders the feed modal
+func setFeedToken(c echo.Context, getFileHandler fileHandlerFunc, token string) error {
+	claims := c.Get(jwtClaimsKey).(JWTClaims)
+	ctx := c.Request().Context()
+	userInfo, err := readUserInfo(ctx, getFileHandler, claims.User)
+	if err != nil {
+		return err
+	}
+	userInfo.FeedToken = token
+	err = writeUserInfo(ctx, getFileHandler, userInfo)
+	if err != nil {
+		return err
+	}
+	return render(c, feedModal(feedURL(c.Request().Host, userInfo)))
+}
+
+// unsubscribeRequest checks the link's token, returning the user and which emails to stop
+func unsubscribeRequest(c echo.Context, getFileHandler fileHandlerFunc) (UserInfo, notificationKind, bool) {
+	userInfo, err := readUserInfo(c.Request().Context(), getFileHandler, c.Param("user"))
+	if err != nil || !validSecretToken(userInfo.Notifications.UnsubscribeToken, c.Param("token")) {
+		// same response whether the user doesn't exist or the token is wrong
+		return UserInfo{}, "", false
+	}
+	kind := notificationKind(c.FormValue("from"))
+	if kind != notifyReminder && kind != notifyDigest {
+		kind = notifyAll
+	}
+	return userInfo, kind, true
+}
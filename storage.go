@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// defaults for the storage section of the Config
+const localDataDir = "localdata"
+const localBackupDir = "backups"
+const s3Bucket = "activity-tracker-lambda-artifacts"
+const s3DataPrefix = "data/"
+const s3BackupPrefix = "backups/"
+
+// Storage is where each user's files live
+type Storage interface {
+	// Open returns a handle that reads the existing file and/or replaces it on Close
+	Open(ctx context.Context, username, fileName string) (io.ReadWriteCloser, error)
+	ListUsers(ctx context.Context) ([]string, error)
+	DeleteUser(ctx context.Context, username string) error
+	// Sub is a storage with the same layout under this one, name is in place of a user
+	Sub(name string) Storage
+}
+
+func newStorage(cfg storageConfig) Storage {
+	if cfg.Local {
+		return LocalStorage{Dir: cfg.LocalDir}
+	}
+	return cfg.s3Storage(cfg.DataPrefix)
+}
+
+// errNotModified is from readIfChanged when the file is still at the version already read
+var errNotModified = errors.New("not modified")
+
+// versionedFile is a handle from Open that can skip reading a file that hasn't changed, see daysCache
+type versionedFile interface {
+	// fileID is unique to the file, across storages
+	fileID() string
+	// readIfChanged starts reading the file unless it's still at version, returning the version being read
+	readIfChanged(version string) (string, error)
+}
+
+// listableFile is a handle from Open that can be used as a directory, see the segmented layout
+type listableFile interface {
+	// list returns the files under the handle's name, relative to it, empty if there are none
+	list() ([]string, error)
+}
+
+// removableFile is a handle from Open that can delete its file, which is fine if it doesn't exist
+type removableFile interface {
+	remove() error
+}
+
+// LocalStorage keeps files under Dir/<user>/<file>
+type LocalStorage struct {
+	Dir string
+}
+
+func (l LocalStorage) Open(ctx context.Context, username, fileName string) (io.ReadWriteCloser, error) {
+	return &LocalFileData{
+		ctx:      ctx,
+		fileName: filepath.Join(l.Dir, username, fileName),
+	}, nil
+}
+
+func (l LocalStorage) ListUsers(ctx context.Context) ([]string, error) {
+	_, op := startStorageOp(ctx, "local", "list", l.Dir)
+	entries, err := os.ReadDir(l.Dir)
+	err = op.end(err)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	var users []string
+	for _, e := range entries {
+		if e.IsDir() {
+			users = append(users, e.Name())
+		}
+	}
+	return users, nil
+}
+
+func (l LocalStorage) DeleteUser(ctx context.Context, username string) error {
+	_, op := startStorageOp(ctx, "local", "delete", filepath.Join(l.Dir, username))
+	err := os.RemoveAll(filepath.Join(l.Dir, username))
+	err = op.end(err)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+func (l LocalStorage) Sub(name string) Storage {
+	return LocalStorage{Dir: filepath.Join(l.Dir, name)}
+}
+
+// S3Storage keeps files at s3://Bucket/Prefix<user>/<file>
+// the rest are for S3-compatible stores like MinIO, credentials come from the usual AWS_ variables and files
+type S3Storage struct {
+	Bucket       string
+	Prefix       string
+	Endpoint     string // e.g. http://localhost:9000, AWS if empty
+	Region       string // from the AWS config if empty
+	UsePathStyle bool   // http://endpoint/bucket/key rather than http://bucket.endpoint/key
+}
+
+// s3ClientKey is what makes clients different, the credentials are the process's either way
+type s3ClientKey struct {
+	endpoint  string
+	region    string
+	pathStyle bool
+}
+
+// s3Clients are made once per process, loading the config is most of a cold request otherwise
+var (
+	s3ClientsMu sync.Mutex
+	s3Clients   = make(map[s3ClientKey]*s3.Client)
+)
+
+func (s S3Storage) client(ctx context.Context) (*s3.Client, error) {
+	key := s3ClientKey{endpoint: s.Endpoint, region: s.Region, pathStyle: s.UsePathStyle}
+	s3ClientsMu.Lock()
+	defer s3ClientsMu.Unlock()
+	if client, ok := s3Clients[key]; ok {
+		return client, nil
+	}
+	// failures aren't kept, the next call tries again
+	client, err := s.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s3Clients[key] = client
+	return client, nil
+}
+
+func (s S3Storage) newClient(ctx context.Context) (*s3.Client, error) {
+	var opts []func(*config.LoadOptions) error
+	if s.Region != "" {
+		opts = append(opts, config.WithRegion(s.Region))
+	}
+	// traced because it can be slow on a cold start
+	ctx, span := tracer.Start(ctx, "s3 load config")
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if s.Endpoint != "" {
+			o.BaseEndpoint = aws.String(s.Endpoint)
+		}
+		o.UsePathStyle = s.UsePathStyle
+	}), nil
+}
+
+func (s S3Storage) Open(ctx context.Context, username, fileName string) (io.ReadWriteCloser, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &S3FileData{
+		ctx:      ctx,
+		s3Client: client,
+		endpoint: s.Endpoint,
+		bucket:   s.Bucket,
+		key:      s.Prefix + username + "/" + fileName,
+	}, nil
+}
+
+func (s S3Storage) ListUsers(ctx context.Context) ([]string, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.Bucket),
+		Prefix:    aws.String(s.Prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		pageCtx, op := startStorageOp(ctx, "s3", "list", s.Prefix)
+		page, err := paginator.NextPage(pageCtx)
+		err = op.end(err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		for _, p := range page.CommonPrefixes {
+			users = append(users, strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), s.Prefix), "/"))
+		}
+	}
+	return users, nil
+}
+
+func (s S3Storage) DeleteUser(ctx context.Context, username string) error {
+	client, err := s.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s.Prefix + username + "/"),
+	})
+	for paginator.HasMorePages() {
+		pageCtx, op := startStorageOp(ctx, "s3", "list", s.Prefix+username+"/")
+		page, err := paginator.NextPage(pageCtx)
+		err = op.end(err)
+		if err != nil {
+			return fmt.Errorf("failed to list user files: %w", err)
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		var objects []types.ObjectIdentifier
+		for _, o := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: o.Key})
+		}
+		deleteCtx, op := startStorageOp(ctx, "s3", "delete", s.Prefix+username+"/")
+		_, err = client.DeleteObjects(deleteCtx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.Bucket),
+			Delete: &types.Delete{Objects: objects},
+		})
+		err = op.end(err)
+		if err != nil {
+			return fmt.Errorf("failed to delete user files: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s S3Storage) Sub(name string) Storage {
+	sub := s
+	sub.Prefix = s.Prefix + name + "/"
+	return sub
+}
+
+type LocalFileData struct {
+	ctx      context.Context
+	fileName string
+	writer   io.WriteCloser
+	reader   io.ReadCloser
+}
+
+func (l *LocalFileData) Read(p []byte) (n int, err error) {
+	if l.reader == nil {
+		_, err = l.readIfChanged("")
+		if err != nil {
+			return 0, err
+		}
+	}
+	return l.reader.Read(p)
+}
+
+func (l *LocalFileData) fileID() string {
+	if abs, err := filepath.Abs(l.fileName); err == nil {
+		return "local:" + abs
+	}
+	return "local:" + l.fileName
+}
+
+// readIfChanged uses the modification time and size as the version
+func (l *LocalFileData) readIfChanged(version string) (string, error) {
+	// no mkdir, reading a missing file shouldn't make the user's directory
+	_, op := startStorageOp(l.ctx, "local", "get", l.fileName)
+	f, err := os.Open(l.fileName)
+	var info fs.FileInfo
+	if err == nil {
+		info, err = f.Stat()
+	}
+	err = op.end(err)
+	if err != nil {
+		if f != nil {
+			safeClose(l.ctx, f, "local file")
+		}
+		return "", fmt.Errorf("failed to open file for reading: %w", err)
+	}
+	current := fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	if current == version {
+		safeClose(l.ctx, f, "local file")
+		return version, errNotModified
+	}
+	l.reader = f
+	return current, nil
+}
+
+func (l *LocalFileData) list() ([]string, error) {
+	_, op := startStorageOp(l.ctx, "local", "list", l.fileName)
+	var names []string
+	err := filepath.WalkDir(l.fileName, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(l.fileName, path)
+		names = append(names, filepath.ToSlash(rel))
+		return err
+	})
+	err = op.end(err)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	return names, nil
+}
+
+func (l *LocalFileData) remove() error {
+	_, op := startStorageOp(l.ctx, "local", "delete", l.fileName)
+	err := os.Remove(l.fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	err = op.end(err)
+	if err != nil {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	return nil
+}
+
+func (l *LocalFileData) Write(p []byte) (n int, err error) {
+	if l.writer == nil {
+		err = os.MkdirAll(filepath.Dir(l.fileName), 0755)
+		if err != nil {
+			return 0, fmt.Errorf("failed to mkdir -p: %w", storageUnavailable(err))
+		}
+
+		_, op := startStorageOp(l.ctx, "local", "put", l.fileName)
+		f, err := os.Create(l.fileName)
+		err = op.end(err)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open file for writing: %w", err)
+		}
+		l.writer = f
+	}
+	return l.writer.Write(p)
+}
+
+func (l *LocalFileData) Close() error {
+	if l.reader != nil {
+		return l.reader.Close()
+	}
+	if l.writer != nil {
+		return l.writer.Close()
+	}
+	return nil
+}
+
+type S3FileData struct {
+	ctx      context.Context
+	s3Client *s3.Client
+	endpoint string
+	bucket   string
+	key      string
+
+	writer *bytes.Buffer
+	reader io.ReadCloser
+}
+
+func (s *S3FileData) Read(p []byte) (n int, err error) {
+	if s.reader == nil {
+		_, err = s.readIfChanged("")
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s.reader.Read(p)
+}
+
+func (s *S3FileData) fileID() string {
+	return "s3:" + s.endpoint + "/" + s.bucket + "/" + s.key
+}
+
+// readIfChanged is a conditional GET, the version is the ETag
+func (s *S3FileData) readIfChanged(version string) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	}
+	if version != "" {
+		input.IfNoneMatch = aws.String(version)
+	}
+	ctx, op := startStorageOp(s.ctx, "s3", "get", s.key)
+	result, err := s.s3Client.GetObject(ctx, input)
+	var httpErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &httpErr) && httpErr.HTTPStatusCode() == http.StatusNotModified {
+		err = errNotModified
+	}
+	err = op.end(err)
+	if errors.Is(err, errNotModified) {
+		return version, err
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not get object: %w", err)
+	}
+	s.reader = result.Body
+	return aws.ToString(result.ETag), nil
+}
+
+func (s *S3FileData) list() ([]string, error) {
+	prefix := s.key + "/"
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(s.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		pageCtx, op := startStorageOp(s.ctx, "s3", "list", prefix)
+		page, err := paginator.NextPage(pageCtx)
+		err = op.end(err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+		for _, o := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.ToString(o.Key), prefix))
+		}
+	}
+	return names, nil
+}
+
+func (s *S3FileData) remove() error {
+	ctx, op := startStorageOp(s.ctx, "s3", "delete", s.key)
+	_, err := s.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	err = op.end(err)
+	if err != nil {
+		return fmt.Errorf("could not delete object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3FileData) Write(p []byte) (n int, err error) {
+	if s.writer == nil {
+		s.writer = &bytes.Buffer{}
+	}
+	return s.writer.Write(p)
+}
+
+func (s *S3FileData) Close() error {
+	if s.writer != nil {
+		ctx, op := startStorageOp(s.ctx, "s3", "put", s.key)
+		_, err := s.s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key),
+			// seekable, unsigned streams are refused over plain http, e.g. to a local MinIO
+			Body: bytes.NewReader(s.writer.Bytes()),
+		})
+		err = op.end(err)
+		if err != nil {
+			return fmt.Errorf("failed to write data to s3: %w", err)
+		}
+		s.writer = nil
+	}
+	if s.reader != nil {
+		err := s.reader.Close()
+		s.reader = nil
+		if err != nil {
+			return fmt.Errorf("failed to closer a reader: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test_storage runs the same calls against each backend, S3 through an in-process fake like a self-hosted MinIO
+func Test_storage(t *testing.T) {
+	s3 := newFakeS3(t, "tracker")
+	backends := map[string]Storage{
+		"local": LocalStorage{Dir: t.TempDir()},
+		"s3":    s3.storage("data/"),
+	}
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			testStorage(t, storage)
+		})
+	}
+
+	// everything was under the prefix, in the bucket from the path
+	keys := s3.keys()
+	if len(keys) == 0 {
+		t.Fatal("nothing was written to the fake S3")
+	}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, "data/") {
+			t.Errorf("key %s isn't under the data/ prefix", k)
+		}
+	}
+}
+
+func testStorage(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	day := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+
+	_, err := readUserInfo(ctx, storage.Open, "alice")
+	if !isNotExist(err) {
+		t.Fatalf("reading a missing user = %v, want not exist", err)
+	}
+	users, err := storage.ListUsers(ctx)
+	if err != nil || len(users) != 0 {
+		t.Fatalf("ListUsers() before any = %v, %v", users, err)
+	}
+
+	for _, u := range []string{"bob", "alice"} {
+		err = createUser(ctx, storage.Open, UserInfo{Username: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: time.Hour, Effort: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second write replaces the file
+	err = addEntry(ctx, storage.Open, "alice", day, DayEntry{Duration: 30 * time.Minute, Effort: 0.8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || len(days[0].Entries) != 2 {
+		t.Errorf("readDays() = %+v, want both entries on one day", days)
+	}
+
+	users, err = storage.ListUsers(ctx)
+	slices.Sort(users)
+	if err != nil || !slices.Equal(users, []string{"alice", "bob"}) {
+		t.Errorf("ListUsers() = %v, %v", users, err)
+	}
+
+	// a sub storage has the same layout under its own name
+	sub := storage.Sub("snapshot")
+	f, err := sub.Open(ctx, "alice", "note.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.WriteString(f, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err = sub.Open(ctx, "alice", "note.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	safeClose(ctx, f, "note")
+	if err != nil || string(got) != "hello" {
+		t.Errorf("read back %q, %v", got, err)
+	}
+	subUsers, err := sub.ListUsers(ctx)
+	if err != nil || !slices.Equal(subUsers, []string{"alice"}) {
+		t.Errorf("sub ListUsers() = %v, %v", subUsers, err)
+	}
+
+	err = storage.DeleteUser(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := userExists(ctx, storage.Open, "bob")
+	if err != nil || exists {
+		t.Errorf("bob exists %v after delete, %v", exists, err)
+	}
+}
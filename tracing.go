@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/jamethy/activity-tracker"
+
+// the standard OTEL_* variables configure the rest, e.g. OTEL_EXPORTER_OTLP_ENDPOINT for where a collector is
+const (
+	tracesExporterEnv = "OTEL_TRACES_EXPORTER" // otlp, console (stdout) or none, the default
+	lambdaTraceIDKey  = "x-amzn-trace-id"      // where aws-lambda-go puts the invocation's X-Ray trace header
+)
+
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing installs the exporter named by traces_exporter (OTEL_TRACES_EXPORTER), when there isn't one spans are dropped
+// incoming trace context is read from traceparent or X-Amzn-Trace-Id headers either way
+func setupTracing(ctx context.Context, exporterName string) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}, xray.Propagator{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case "", "none":
+		return nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "console", "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	default:
+		return fmt.Errorf("unknown traces exporter %q, expected otlp, console or none", exporterName)
+	}
+	if err != nil {
+		return fmt.Errorf("creating trace exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("activity-tracker")))
+	if err != nil {
+		return fmt.Errorf("creating trace resource: %w", err)
+	}
+	// the environment (OTEL_SERVICE_NAME, OTEL_RESOURCE_ATTRIBUTES) wins
+	res, err = resource.Merge(res, resource.Environment())
+	if err != nil {
+		return fmt.Errorf("creating trace resource: %w", err)
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)))
+	return nil
+}
+
+// flushTraces exports the spans that are batched up, lambdas are frozen between invocations so this is after each one
+func flushTraces(ctx context.Context) {
+	if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		_ = tp.ForceFlush(ctx)
+	}
+}
+
+func shutdownTracing(ctx context.Context) {
+	if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		_ = tp.Shutdown(ctx)
+	}
+}
+
+// addLambdaTraceHeader passes the invocation's X-Ray trace on to echo, unless the caller sent their own trace context
+func addLambdaTraceHeader(ctx context.Context, req *http.Request) {
+	if req.Header.Get("traceparent") != "" || req.Header.Get("X-Amzn-Trace-Id") != "" {
+		return
+	}
+	if id, ok := ctx.Value(lambdaTraceIDKey).(string); ok && id != "" {
+		req.Header.Set("X-Amzn-Trace-Id", id)
+	}
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// storageOp is a traced and measured call to a storage backend, see observeStorage
+type storageOp struct {
+	backend   string
+	operation string
+	start     time.Time
+	key       string
+	span      trace.Span
+	log       *slog.Logger
+}
+
+func startStorageOp(ctx context.Context, backend, operation, key string) (context.Context, storageOp) {
+	ctx, span := tracer.Start(ctx, backend+" "+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("storage.backend", backend),
+		attribute.String("storage.key", key),
+	))
+	return ctx, storageOp{backend: backend, operation: operation, key: key, start: time.Now(), span: span, log: loggerFrom(ctx)}
+}
+
+// end records the outcome, failures are returned as storage unavailable errors
+// files not existing yet, or not modified since they were cached, are normal answers
+func (op storageOp) end(err error) error {
+	if errors.Is(err, errNotModified) {
+		observeStorage(op.backend, op.operation, op.start, nil)
+		op.log.Debug("storage operation", "backend", op.backend, "operation", op.operation, "key", op.key, "ms", time.Since(op.start).Milliseconds(), "not_modified", true)
+		op.span.SetAttributes(attribute.Bool("storage.not_modified", true))
+		endSpan(op.span, nil)
+		return err
+	}
+
+	observeStorage(op.backend, op.operation, op.start, err)
+	attrs := []any{"backend", op.backend, "operation", op.operation, "key", op.key, "ms", time.Since(op.start).Milliseconds()}
+	if err != nil && !isNotExist(err) {
+		op.log.Warn("storage operation failed", append(attrs, "err", err)...)
+	} else {
+		op.log.Debug("storage operation", append(attrs, "not_found", err != nil)...)
+	}
+	if isNotExist(err) {
+		op.span.SetAttributes(attribute.Bool("storage.not_found", true))
+		endSpan(op.span, nil)
+	} else {
+		endSpan(op.span, err)
+	}
+	return storageUnavailable(err)
+}
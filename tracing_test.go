@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_tracing(t *testing.T) {
+	err := setupTracing(context.Background(), "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	storage := LocalStorage{Dir: t.TempDir()}
+	dispatch := LambdaDispatcher(newServer(storage, Config{}), nil)
+	payload, err := os.ReadFile(filepath.Join("testdata", "lambda", "api-gateway-v2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what aws-lambda-go adds for an invocation with X-Ray on
+	ctx := context.WithValue(context.Background(), lambdaTraceIDKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	_, err = dispatch(ctx, json.RawMessage(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	request, ok := spans["/login"]
+	if !ok {
+		t.Fatalf("no span for the request in %v", spans)
+	}
+	if got := request.SpanContext().TraceID().String(); got != "5759e988bd862e3fe1be46a994272793" {
+		t.Errorf("request trace id = %s, want the lambda's X-Ray trace", got)
+	}
+	if got := request.Parent().SpanID().String(); got != "53995c3f42cd8ad8" {
+		t.Errorf("request parent = %s, want the lambda's X-Ray segment", got)
+	}
+	render, ok := spans["render"]
+	if !ok || render.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("render span %v isn't a child of the request", render)
+	}
+
+	// missing files aren't errors
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, err = readUserInfo(ctx, storage.Open, "nobody")
+	parent.End()
+	if !isNotExist(err) {
+		t.Fatalf("readUserInfo() = %v, want not exist", err)
+	}
+	var get sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "local get" && s.Parent().SpanID() == parent.SpanContext().SpanID() {
+			get = s
+		}
+	}
+	if get == nil {
+		t.Fatal("no span for the storage read")
+	}
+	notFound := false
+	for _, a := range get.Attributes() {
+		if a == attribute.Bool("storage.not_found", true) {
+			notFound = true
+		}
+	}
+	if get.Status().Code != 0 || !notFound {
+		t.Errorf("storage span status %v, attributes %v, want unset and not found", get.Status(), get.Attributes())
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const tuiDays = 14
+const tuiBarWidth = 20 // characters for tuiBarMax
+const tuiBarMax = 2 * time.Hour
+
+const tuiHelp = "[a]dd  [e]dit  [d]elete  [r]efresh  [q]uit"
+
+// tui is a terminal version of the tracker page, it reads keys from in and draws to out
+type tui struct {
+	ctx            context.Context
+	getFileHandler fileHandlerFunc
+	claims         JWTClaims
+	now            func() time.Time
+
+	in     *bufio.Reader
+	out    io.Writer
+	rawFd  int // terminal file descriptor for single key presses, -1 if not a terminal
+	status string
+
+	view    trackerView
+	entries []tuiEntry // numbered entries on screen, for edit and delete
+}
+
+type tuiEntry struct {
+	Date  time.Time
+	Index int
+	Entry DayEntry
+}
+
+func newTUI(ctx context.Context, getFileHandler fileHandlerFunc, claims JWTClaims, in io.Reader, out io.Writer) *tui {
+	t := &tui{
+		ctx:            ctx,
+		getFileHandler: getFileHandler,
+		claims:         claims,
+		now:            time.Now,
+		in:             bufio.NewReader(in),
+		out:            out,
+		rawFd:          -1,
+	}
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		t.rawFd = int(f.Fd())
+	}
+	return t
+}
+
+func (t *tui) Run() error {
+	for {
+		err := t.load()
+		if err != nil {
+			return err
+		}
+		t.draw()
+
+		key, err := t.readKey()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		t.status = ""
+		switch key {
+		case 'q':
+			return nil
+		case 'a':
+			err = t.add()
+		case 'e':
+			err = t.edit()
+		case 'd':
+			err = t.delete()
+		case 'r', '\n', '\r':
+		default:
+			t.status = tuiHelp
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			t.status = "error: " + err.Error()
+		}
+	}
+}
+
+func (t *tui) load() error {
+	v, err := loadTrackerView(t.ctx, t.getFileHandler, t.claims, t.now())
+	if err != nil {
+		return err
+	}
+	t.view = v
+	t.entries = nil
+	for _, d := range v.Days[:min(tuiDays, len(v.Days))] {
+		for i, e := range d.Entries {
+			t.entries = append(t.entries, tuiEntry{Date: d.Date, Index: i, Entry: e})
+		}
+	}
+	return nil
+}
+
+func (t *tui) draw() {
+	var b strings.Builder
+	if t.rawFd >= 0 {
+		b.WriteString("\033[H\033[2J") // home and clear
+	}
+
+	s := t.view.Summary
+	_, _ = fmt.Fprintf(&b, "Activity Tracker  %s of goal  %s moderate remaining\n", scoreStr(s.ComboScore), sumStr(s.RemainingModerateTime))
+	_, _ = fmt.Fprintf(&b, "  low %s / %s   moderate %s / %s   high %s / %s\n",
+		scoreStr(s.LowIntensityScore), sumStr(s.LowIntensitySum),
+		scoreStr(s.ModerateIntensityScore), sumStr(s.ModerateIntensitySum),
+		scoreStr(s.HighIntensityScore), sumStr(s.HighIntensitySum),
+	)
+	for _, st := range t.view.Achievements.Streaks {
+		_, _ = fmt.Fprintf(&b, "  %s streak: %s", st.Name, streakStr(st))
+	}
+	b.WriteString("\n\n")
+
+	n := 0
+	for i, d := range t.view.Days[:min(tuiDays, len(t.view.Days))] {
+		if i == 7 {
+			b.WriteString("  ---- previous week ----\n")
+		}
+		_, _ = fmt.Fprintf(&b, "  %s\n", d.Date.Format("Mon Jan _2"))
+		if len(d.Entries) == 0 {
+			b.WriteString(t.color("#8b0000", "        nothing") + "\n")
+		}
+		for _, e := range d.Entries {
+			n++
+			_, _ = fmt.Fprintf(&b, "  %4d  %s %s %s\n", n, t.bar(e), sumStr(e.Duration), e.Label())
+		}
+	}
+
+	b.WriteString("\n" + tuiHelp + "\n")
+	if t.status != "" {
+		b.WriteString(t.status + "\n")
+	}
+	_, _ = io.WriteString(t.out, strings.ReplaceAll(b.String(), "\n", t.newline()))
+}
+
+// bar is the terminal version of effortClass, length is duration and color is effort
+func (t *tui) bar(e DayEntry) string {
+	width := int(min(1, float64(e.Duration)/float64(tuiBarMax)) * tuiBarWidth)
+	return t.color(effortColor(e), fmt.Sprintf("%-*s", tuiBarWidth, strings.Repeat("█", max(1, width))))
+}
+
+// color uses a 24-bit ansi foreground, only on a terminal
+func (t *tui) color(hex, s string) string {
+	if t.rawFd < 0 || len(hex) < 7 {
+		return s
+	}
+	r, _ := strconv.ParseUint(hex[1:3], 16, 8)
+	g, _ := strconv.ParseUint(hex[3:5], 16, 8)
+	bl, _ := strconv.ParseUint(hex[5:7], 16, 8)
+	return fmt.Sprintf("\033[38;2;%d;%d;%dm%s\033[0m", r, g, bl, s)
+}
+
+func (t *tui) newline() string {
+	if t.rawFd >= 0 {
+		// raw mode doesn't return the carriage
+		return "\r\n"
+	}
+	return "\n"
+}
+
+func (t *tui) add() error {
+	form := newEntryForm(t.now().Format(time.DateOnly))
+	err := t.promptForm(&form)
+	if err != nil {
+		return err
+	}
+	date, entry, ok := form.parse()
+	if !ok {
+		return formErrors(form)
+	}
+	err = addEntry(t.ctx, t.getFileHandler, t.claims.User, date, entry)
+	if err != nil {
+		return err
+	}
+	sendEntryWebhooks(t.ctx, t.getFileHandler, t.claims.User, webhookEntryCreated, date, entry, nil, t.now())
+	t.status = fmt.Sprintf("added %s %s", sumStr(entry.Duration), entry.Label())
+	return nil
+}
+
+func (t *tui) edit() error {
+	selected, err := t.promptEntry("edit")
+	if err != nil {
+		return err
+	}
+
+	form := entryForm{
+		Date:        selected.Date.Format(time.DateOnly),
+		Duration:    sumStr(selected.Entry.Duration),
+		Effort:      selected.Entry.Effort,
+		Type:        selected.Entry.Type,
+		Description: selected.Entry.Description,
+	}
+	err = t.promptForm(&form)
+	if err != nil {
+		return err
+	}
+	date, entry, ok := form.parse()
+	if !ok {
+		return formErrors(form)
+	}
+	if entry.StartTime.IsZero() && date.Equal(selected.Date) {
+		entry.StartTime = selected.Entry.StartTime
+	}
+
+	err = updateEntry(t.ctx, t.getFileHandler, t.claims.User, selected.Date, selected.Index, date, entry)
+	if err != nil {
+		return err
+	}
+	sendEntryWebhooks(t.ctx, t.getFileHandler, t.claims.User, webhookEntryUpdated, date, entry, newWebhookEntry(selected.Date, selected.Entry), t.now())
+	t.status = fmt.Sprintf("updated %s %s", sumStr(entry.Duration), entry.Label())
+	return nil
+}
+
+func (t *tui) delete() error {
+	selected, err := t.promptEntry("delete")
+	if err != nil {
+		return err
+	}
+	removed, err := deleteEntry(t.ctx, t.getFileHandler, t.claims.User, selected.Date, selected.Index)
+	if err != nil {
+		return err
+	}
+	sendEntryWebhooks(t.ctx, t.getFileHandler, t.claims.User, webhookEntryDeleted, selected.Date, removed, nil, t.now())
+	t.status = fmt.Sprintf("deleted %s %s", sumStr(removed.Duration), removed.Label())
+	return nil
+}
+
+// promptForm asks for each field, showing the current value which is kept if nothing is entered
+func (t *tui) promptForm(form *entryForm) error {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{name: "date", value: &form.Date},
+		{name: "duration", value: &form.Duration},
+		{name: "type", value: &form.Type},
+		{name: "description", value: &form.Description},
+	}
+	for _, f := range fields {
+		v, err := t.prompt(fmt.Sprintf("%s [%s]: ", f.name, *f.value))
+		if err != nil {
+			return err
+		}
+		if v != "" {
+			*f.value = v
+		}
+	}
+
+	// default the effort from the type, like the web form does
+	activityTypes, err := loadActivityTypes(t.ctx, t.getFileHandler, t.claims.User)
+	if err != nil {
+		return err
+	}
+	if at, ok := findActivityType(activityTypes, form.Type); ok && form.Effort == defaultEffort {
+		form.Effort = at.DefaultEffort
+	}
+
+	v, err := t.prompt(fmt.Sprintf("effort 0-1 [%s]: ", form.EffortStr()))
+	if err != nil {
+		return err
+	}
+	if v != "" {
+		effort, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return errors.New("effort must be a number")
+		}
+		form.Effort = float32(effort)
+	}
+	return nil
+}
+
+func (t *tui) promptEntry(action string) (tuiEntry, error) {
+	if len(t.entries) == 0 {
+		return tuiEntry{}, fmt.Errorf("nothing to %s", action)
+	}
+	v, err := t.prompt(fmt.Sprintf("%s entry # (1-%d): ", action, len(t.entries)))
+	if err != nil {
+		return tuiEntry{}, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > len(t.entries) {
+		return tuiEntry{}, fmt.Errorf("no entry %q", v)
+	}
+	return t.entries[n-1], nil
+}
+
+// prompt reads a whole line, in cooked mode so editing works
+func (t *tui) prompt(p string) (string, error) {
+	_, _ = io.WriteString(t.out, p)
+	line, err := t.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// readKey reads a single key press on a terminal, otherwise the first character of a line
+func (t *tui) readKey() (byte, error) {
+	if t.rawFd < 0 {
+		line, err := t.in.ReadString('\n')
+		if err != nil && line == "" {
+			return 0, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return '\n', nil
+		}
+		return line[0], nil
+	}
+
+	state, err := term.MakeRaw(t.rawFd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make terminal raw: %w", err)
+	}
+	defer func() { _ = term.Restore(t.rawFd, state) }()
+
+	key, err := t.in.ReadByte()
+	if key == 3 || key == 4 {
+		// ctrl-c and ctrl-d
+		return 'q', nil
+	}
+	return key, err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_tui(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	userInfo := UserInfo{Username: "alice", RestingHeartrate: 62, DateOfBirth: time.Date(1985, 3, 4, 0, 0, 0, 0, time.UTC)}
+	err := createUser(ctx, storage.Open, userInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 17, 12, 0, 0, 0, time.UTC)
+	today := time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)
+	run := func(keys string) string {
+		var out bytes.Buffer
+		ui := newTUI(ctx, storage.Open, newJWTClaims(userInfo, now), strings.NewReader(keys), &out)
+		ui.now = func() time.Time { return now }
+		err := ui.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	entries := func() []DayEntry {
+		days, err := readDays(ctx, storage.Open, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var es []DayEntry
+		for _, d := range days {
+			es = append(es, d.Entries...)
+		}
+		return es
+	}
+
+	// add with the default date, the type's default effort
+	out := run("a\n\n45m\nrun\nmorning\n\nq\n")
+	if !strings.Contains(out, "added 45m morning") {
+		t.Errorf("add output = %q", out)
+	}
+	es := entries()
+	if len(es) != 1 || es[0].Duration != 45*time.Minute || es[0].Effort != 0.8 || es[0].Description != "morning" {
+		t.Fatalf("after add = %+v", es)
+	}
+
+	// edit keeps anything left blank
+	out = run("e\n1\n\n1h\n\n\n\nq\n")
+	if !strings.Contains(out, "updated 1h morning") {
+		t.Errorf("edit output = %q", out)
+	}
+	es = entries()
+	if len(es) != 1 || es[0].Duration != time.Hour || es[0].Type != "run" || es[0].Description != "morning" {
+		t.Fatalf("after edit = %+v", es)
+	}
+
+	// moving to another day
+	run("e\n1\n2024-06-16\n\n\n\n\nq\n")
+	days, err := readDays(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || !days[0].Date.Equal(today.AddDate(0, 0, -1)) {
+		t.Fatalf("after move = %+v", days)
+	}
+
+	// bad input shows an error and keeps going
+	out = run("e\n7\nd\nnope\nq\n")
+	if !strings.Contains(out, `error: no entry "7"`) || !strings.Contains(out, `error: no entry "nope"`) {
+		t.Errorf("bad input output = %q", out)
+	}
+
+	out = run("d\n1\n")
+	if !strings.Contains(out, "deleted 1h morning") {
+		t.Errorf("delete output = %q", out)
+	}
+	if es := entries(); len(es) != 0 {
+		t.Errorf("after delete = %+v", es)
+	}
+}
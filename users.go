@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UserInfo struct {
+	Username         string
+	Password         string
+	RestingHeartrate float64
+	DateOfBirth      time.Time
+	ScoringModel     string // see scoringModels, empty is the default
+	FeedToken        string // secret for the calendar feed, empty if there isn't one
+	Admin            bool   // can use the /admin endpoints
+	Notifications    NotificationSettings
+}
+
+const minPasswordLength = 8
+
+// usernames end up in file paths and urls
+var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// userInput is the raw fields for a new user e.g. from flags, empty fields are prompted for
+type userInput struct {
+	Username         string
+	Password         string
+	RestingHeartrate string
+	DateOfBirth      string
+}
+
+func readUserInfo(ctx context.Context, getFileHandler fileHandlerFunc, username string) (UserInfo, error) {
+	f, err := getFileHandler(ctx, username, userInfoFileName)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	defer safeClose(ctx, f, "read user info")
+
+	var userInfo UserInfo
+	err = json.NewDecoder(f).Decode(&userInfo)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("failed parsing user info: %w", err)
+	}
+	return userInfo, nil
+}
+
+func writeUserInfo(ctx context.Context, getFileHandler fileHandlerFunc, userInfo UserInfo) error {
+	f, err := getFileHandler(ctx, userInfo.Username, userInfoFileName)
+	if err != nil {
+		return err
+	}
+	defer safeClose(ctx, f, "write user info")
+
+	err = json.NewEncoder(f).Encode(userInfo)
+	if err != nil {
+		return fmt.Errorf("failed writing user info: %w", err)
+	}
+	return nil
+}
+
+// userExists is true if the user has a user info file
+func userExists(ctx context.Context, getFileHandler fileHandlerFunc, username string) (bool, error) {
+	_, err := readUserInfo(ctx, getFileHandler, username)
+	if isNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// createUser writes the user info and an empty data file
+func createUser(ctx context.Context, getFileHandler fileHandlerFunc, userInfo UserInfo) error {
+	exists, err := userExists(ctx, getFileHandler, userInfo.Username)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("user %s already exists", userInfo.Username)
+	}
+
+	err = writeUserInfo(ctx, getFileHandler, userInfo)
+	if err != nil {
+		return err
+	}
+	return writeDays(ctx, getFileHandler, userInfo.Username, nil)
+}
+
+// userInfoFromIO builds a user from in, prompting on w for any empty fields until they are valid
+// preset fields that are invalid are an error so scripts fail instead of hanging
+func userInfoFromIO(r io.Reader, w io.Writer, in userInput) (UserInfo, error) {
+	u := UserInfo{}
+	buffedReader := bufio.NewReader(r)
+
+	ask := func(prompt, preset string, parse func(string) error) error {
+		if preset != "" {
+			err := parse(preset)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", prompt, err)
+			}
+			return nil
+		}
+		for {
+			_, _ = fmt.Fprintf(w, "%s: ", prompt)
+			line, readErr := buffedReader.ReadString('\n')
+			line = strings.TrimSpace(line)
+			if readErr != nil && line == "" {
+				return fmt.Errorf("no %s given: %w", prompt, readErr)
+			}
+			err := parse(line)
+			if err == nil {
+				return nil
+			}
+			_, _ = fmt.Fprintf(w, "invalid %s: %s\n", prompt, err)
+			if readErr != nil {
+				return fmt.Errorf("invalid %s: %w", prompt, err)
+			}
+		}
+	}
+
+	err := ask("username", in.Username, func(s string) error {
+		u.Username = s
+		return validateUsername(s)
+	})
+	if err != nil {
+		return u, err
+	}
+
+	err = ask("password", in.Password, func(s string) error {
+		var err error
+		u.Password, err = hashPassword(s)
+		return err
+	})
+	if err != nil {
+		return u, err
+	}
+
+	err = ask("resting heart rate", in.RestingHeartrate, func(s string) error {
+		var err error
+		u.RestingHeartrate, err = parseRestingHeartRate(s)
+		return err
+	})
+	if err != nil {
+		return u, err
+	}
+
+	err = ask(fmt.Sprintf("date of birth (%s)", time.DateOnly), in.DateOfBirth, func(s string) error {
+		var err error
+		u.DateOfBirth, err = parseDateOfBirth(s, time.Now())
+		return err
+	})
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+func validateUsername(username string) error {
+	if !usernameRegex.MatchString(username) {
+		return errors.New("must be 1-64 letters, numbers, dots, dashes or underscores")
+	}
+	return nil
+}
+
+// hashPassword validates and bcrypts the password
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("must be at least %d characters", minPasswordLength)
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(b), nil
+}
+
+func parseRestingHeartRate(s string) (float64, error) {
+	hr, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.New("must be a number")
+	}
+	if hr < 20 || hr > 150 {
+		return 0, errors.New("must be between 20 and 150 BPM")
+	}
+	return hr, nil
+}
+
+func parseDateOfBirth(s string, now time.Time) (time.Time, error) {
+	dob, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must look like %s", time.DateOnly)
+	}
+	if dob.After(now) || dob.Year() < 1900 {
+		return time.Time{}, errors.New("must be in the past")
+	}
+	return dob, nil
+}
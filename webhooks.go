@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const webhooksFileName = "webhooks.json"
+
+// webhookEvent is what a webhook can subscribe to, sent as X-Tracker-Event
+type webhookEvent string
+
+const (
+	webhookEntryCreated webhookEvent = "entry.created"
+	webhookEntryUpdated webhookEvent = "entry.updated"
+	webhookEntryDeleted webhookEvent = "entry.deleted"
+	webhookGoalMet      webhookEvent = "goal.met"    // the week's combo score reached 100%
+	webhookGoalMissed   webhookEvent = "goal.missed" // the week ended short, sent by the webhooks job
+)
+
+var webhookEvents = []webhookEvent{webhookEntryCreated, webhookEntryUpdated, webhookEntryDeleted, webhookGoalMet, webhookGoalMissed}
+
+// webhookBackoff is how long to wait after each failed attempt, it gives up once they run out
+var webhookBackoff = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour, 12 * time.Hour}
+
+// webhookLogSize is how many deliveries are kept per user, newest first
+const webhookLogSize = 50
+
+// webhookClient only connects to public addresses, see checkWebhookDial
+// it has no proxy, which would be what's dialed and checked instead
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: checkWebhookDial}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// webhookAddrAllowed is whether webhooks can be sent to an address, tests allow their local receivers
+var webhookAddrAllowed = isPublicAddr
+
+// isPublicAddr rules out this host, private networks and link-local ones, like the cloud metadata service at 169.254.169.254
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// checkWebhookDial runs on every connection after the host is resolved, so it also covers names resolving to internal addresses, and redirects
+func checkWebhookDial(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !webhookAddrAllowed(addrPort.Addr()) {
+		return fmt.Errorf("webhooks can't be sent to %s", addrPort.Addr())
+	}
+	return nil
+}
+
+// webhookBatch is the most deliveries sent at a time, and webhookClaimFor is longer than sending them takes
+const webhookBatch = 20
+const webhookClaimFor = 5 * time.Minute
+
+// webhookSends are deliveries being sent in the background, the process waits for them before exiting
+var webhookSends sync.WaitGroup
+
+// webhookConfig is a user's webhooks file
+type webhookConfig struct {
+	Hooks      []webhook
+	Deliveries []webhookDelivery       // newest first
+	GoalWeeks  map[string]webhookEvent // the goal event last sent for each week (by monday), so it isn't sent twice
+}
+
+type webhook struct {
+	ID      string
+	URL     string
+	Secret  string // signs the payloads, see signWebhook
+	Events  []webhookEvent
+	Created time.Time
+}
+
+type webhookDelivery struct {
+	ID          string
+	HookID      string
+	URL         string
+	Event       webhookEvent
+	Payload     json.RawMessage
+	Created     time.Time
+	Attempts    int
+	LastAttempt time.Time
+	NextAttempt time.Time // zero once delivered or given up on
+	StatusCode  int       // of the last attempt, 0 if there was no response
+	Error       string    // of the last attempt
+	Delivered   bool
+}
+
+func (d webhookDelivery) Pending() bool {
+	return !d.Delivered && !d.NextAttempt.IsZero()
+}
+
+// webhookPayload is the json body, entry events have Date and Entry, goal events have Week and Summary
+type webhookPayload struct {
+	ID       string          `json:"id"`
+	Event    webhookEvent    `json:"event"`
+	Time     time.Time       `json:"time"`
+	User     string          `json:"user"`
+	Entry    *webhookEntry   `json:"entry,omitempty"`
+	Previous *webhookEntry   `json:"previous,omitempty"` // the entry before it was updated
+	Week     string          `json:"week,omitempty"`
+	Summary  *webhookSummary `json:"summary,omitempty"`
+}
+
+type webhookEntry struct {
+	Date string `json:"date"`
+	entryJSON
+}
+
+func newWebhookEntry(date time.Time, entry DayEntry) *webhookEntry {
+	return &webhookEntry{Date: date.Format(time.DateOnly), entryJSON: entry.toJSON()}
+}
+
+type webhookSummary struct {
+	ComboScore            float64       `json:"comboScore"`
+	LowIntensitySum       time.Duration `json:"lowIntensitySum"` // nanoseconds
+	ModerateIntensitySum  time.Duration `json:"moderateIntensitySum"`
+	HighIntensitySum      time.Duration `json:"highIntensitySum"`
+	RemainingModerateTime time.Duration `json:"remainingModerateTime"`
+}
+
+func newWebhookSummary(s Summary) *webhookSummary {
+	return &webhookSummary{
+		ComboScore:            s.ComboScore,
+		LowIntensitySum:       s.LowIntensitySum,
+		ModerateIntensitySum:  s.ModerateIntensitySum,
+		HighIntensitySum:      s.HighIntensitySum,
+		RemainingModerateTime: s.RemainingModerateTime,
+	}
+}
+
+func readWebhooks(ctx context.Context, getFileHandler fileHandlerFunc, username string) (webhookConfig, error) {
+	f, err := getFileHandler(ctx, username, webhooksFileName)
+	if err != nil {
+		return webhookConfig{}, err
+	}
+	defer safeClose(ctx, f, "read webhooks")
+
+	// most users have no webhooks file
+	var config webhookConfig
+	err = json.NewDecoder(f).Decode(&config)
+	if err != nil && !isNotExist(err) && !errors.Is(err, io.EOF) {
+		return webhookConfig{}, fmt.Errorf("failed parsing webhooks: %w", err)
+	}
+	return config, nil
+}
+
+// webhookLocks is a mutex for each user, so the requests and the webhooks job don't write over each other's changes
+var webhookLocks sync.Map
+
+// updateWebhooks reads, edits and writes a user's webhooks file while holding their lock, it's only written if edit returns true
+func updateWebhooks(ctx context.Context, getFileHandler fileHandlerFunc, username string, edit func(config *webhookConfig) (bool, error)) error {
+	mu, _ := webhookLocks.LoadOrStore(username, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	config, err := readWebhooks(ctx, getFileHandler, username)
+	if err != nil {
+		return err
+	}
+	changed, err := edit(&config)
+	if err != nil || !changed {
+		return err
+	}
+	return writeWebhooks(ctx, getFileHandler, username, config)
+}
+
+func writeWebhooks(ctx context.Context, getFileHandler fileHandlerFunc, username string, config webhookConfig) error {
+	if len(config.Deliveries) > webhookLogSize {
+		config.Deliveries = config.Deliveries[:webhookLogSize]
+	}
+
+	f, err := getFileHandler(ctx, username, webhooksFileName)
+	if err != nil {
+		return err
+	}
+	defer safeClose(ctx, f, "write webhooks")
+
+	err = json.NewEncoder(f).Encode(config)
+	if err != nil {
+		return fmt.Errorf("failed writing webhooks: %w", err)
+	}
+	return nil
+}
+
+// addWebhook validates and saves a new webhook, returning it with its secret
+func addWebhook(ctx context.Context, getFileHandler fileHandlerFunc, username, rawURL string, events []webhookEvent, now time.Time) (webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return webhook{}, fmt.Errorf("%q isn't an http(s) url", rawURL)
+	}
+	// names are checked when they're sent to, these would never work
+	if ip, err := netip.ParseAddr(u.Hostname()); strings.EqualFold(u.Hostname(), "localhost") || (err == nil && !webhookAddrAllowed(ip)) {
+		return webhook{}, fmt.Errorf("webhooks can't be sent to %s", u.Hostname())
+	}
+	if len(events) == 0 {
+		return webhook{}, errors.New("pick at least one event")
+	}
+	for _, e := range events {
+		if !slices.Contains(webhookEvents, e) {
+			return webhook{}, fmt.Errorf("unknown event %q", e)
+		}
+	}
+
+	hook := webhook{URL: u.String(), Events: events, Created: now}
+	hook.ID, err = newWebhookID()
+	if err != nil {
+		return webhook{}, err
+	}
+	hook.Secret, err = newSecretToken()
+	if err != nil {
+		return webhook{}, err
+	}
+	return hook, updateWebhooks(ctx, getFileHandler, username, func(config *webhookConfig) (bool, error) {
+		config.Hooks = append(config.Hooks, hook)
+		return true, nil
+	})
+}
+
+// deleteWebhook removes the webhook, its pending deliveries are given up on when next retried
+func deleteWebhook(ctx context.Context, getFileHandler fileHandlerFunc, username, id string) error {
+	return updateWebhooks(ctx, getFileHandler, username, func(config *webhookConfig) (bool, error) {
+		i := slices.IndexFunc(config.Hooks, func(h webhook) bool { return h.ID == id })
+		if i < 0 {
+			return false, notFoundError(fmt.Sprintf("no webhook %s", id))
+		}
+		config.Hooks = slices.Delete(config.Hooks, i, i+1)
+		return true, nil
+	})
+}
+
+func newWebhookID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// signWebhook is sent as X-Tracker-Signature, receivers recompute it over the raw body with their secret
+func signWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendEntryWebhooks tells the user's webhooks about an entry change, and whether it met the week's goal
+// they're queued and sent in the background, what isn't sent is left to the webhooks job
+// failures are only logged, the entry has already changed
+func sendEntryWebhooks(ctx context.Context, getFileHandler fileHandlerFunc, username string, event webhookEvent, date time.Time, entry DayEntry, previous *webhookEntry, now time.Time) {
+	queued, err := queueEntryWebhooks(ctx, getFileHandler, username, event, date, entry, previous, now)
+	if err != nil {
+		loggerFrom(ctx).Warn("failed queueing webhooks", "user", username, "event", event, "err", err)
+	}
+	if !queued {
+		return
+	}
+
+	// not cut short by the response being sent
+	ctx = context.WithoutCancel(ctx)
+	webhookSends.Add(1)
+	go func() {
+		defer webhookSends.Done()
+		err := deliverWebhooks(ctx, getFileHandler, username, now)
+		if err != nil {
+			loggerFrom(ctx).Warn("failed sending webhooks", "user", username, "event", event, "err", err)
+		}
+	}()
+}
+
+// queueEntryWebhooks returns whether there's anything to send
+func queueEntryWebhooks(ctx context.Context, getFileHandler fileHandlerFunc, username string, event webhookEvent, date time.Time, entry DayEntry, previous *webhookEntry, now time.Time) (bool, error) {
+	queued := false
+	err := updateWebhooks(ctx, getFileHandler, username, func(config *webhookConfig) (bool, error) {
+		if len(config.Hooks) == 0 {
+			return false, nil
+		}
+		before := len(config.Deliveries)
+		err := config.emit(webhookPayload{Event: event, User: username, Entry: newWebhookEntry(date, entry), Previous: previous}, now)
+		if err != nil {
+			return false, err
+		}
+
+		monday := startOfWeek(date)
+		week := monday.Format(time.DateOnly)
+		if config.GoalWeeks[week] != webhookGoalMet {
+			s, err := weekSummary(ctx, getFileHandler, username, monday, now)
+			if err != nil {
+				return false, err
+			}
+			if s.ComboScore >= 100 {
+				err = config.emitGoal(webhookGoalMet, username, monday, s, now)
+				if err != nil {
+					return false, err
+				}
+			}
+		}
+		queued = len(config.Deliveries) > before
+		return true, nil
+	})
+	return queued, err
+}
+
+// runWebhooksJob sends the deliveries that are due, and goal.missed for last week, for every user
+func runWebhooksJob(ctx context.Context, storage Storage, now time.Time) error {
+	users, err := storage.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, u := range users {
+		err = webhooksJobForUser(ctx, storage.Open, u, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhooks for %s: %w", u, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func webhooksJobForUser(ctx context.Context, getFileHandler fileHandlerFunc, username string, now time.Time) error {
+	err := updateWebhooks(ctx, getFileHandler, username, func(config *webhookConfig) (bool, error) {
+		if len(config.Hooks) == 0 {
+			return false, nil
+		}
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		lastMonday := startOfWeek(today).AddDate(0, 0, -7)
+		if config.GoalWeeks[lastMonday.Format(time.DateOnly)] != "" {
+			return false, nil
+		}
+		// the week was over before there were any webhooks
+		first := slices.MinFunc(config.Hooks, func(a, b webhook) int { return a.Created.Compare(b.Created) })
+		if lastMonday.AddDate(0, 0, 7).Before(first.Created) {
+			return false, nil
+		}
+		s, err := weekSummary(ctx, getFileHandler, username, lastMonday, now)
+		if err != nil || s.ComboScore >= 100 {
+			return false, err
+		}
+		return true, config.emitGoal(webhookGoalMissed, username, lastMonday, s, now)
+	})
+	if err != nil {
+		return err
+	}
+	return deliverWebhooks(ctx, getFileHandler, username, now)
+}
+
+// deliverWebhooks sends the user's deliveries that are due, oldest first
+// they're claimed before being sent, so another send or the job doesn't send them too
+func deliverWebhooks(ctx context.Context, getFileHandler fileHandlerFunc, username string, now time.Time) error {
+	var due []webhookDelivery
+	var hooks []webhook
+	err := updateWebhooks(ctx, getFileHandler, username, func(config *webhookConfig) (bool, error) {
+		for i := len(config.Deliveries) - 1; i >= 0 && len(due) < webhookBatch; i-- {
+			d := &config.Deliveries[i]
+			if d.Pending() && !d.NextAttempt.After(now) {
+				due = append(due, *d)
+				d.NextAttempt = now.Add(webhookClaimFor)
+			}
+		}
+		hooks = config.Hooks
+		return len(due) > 0, nil
+	})
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	for i := range due {
+		attemptWebhook(ctx, hooks, &due[i], now)
+	}
+
+	return updateWebhooks(ctx, getFileHandler, username, func(config *webhookConfig) (bool, error) {
+		for _, d := range due {
+			// it's gone if enough newer ones were logged since
+			i := slices.IndexFunc(config.Deliveries, func(c webhookDelivery) bool { return c.ID == d.ID })
+			if i >= 0 {
+				config.Deliveries[i] = d
+			}
+		}
+		return true, nil
+	})
+}
+
+// weekSummary is the user's summary of the seven days starting monday
+func weekSummary(ctx context.Context, getFileHandler fileHandlerFunc, username string, monday time.Time, now time.Time) (Summary, error) {
+	userInfo, err := readUserInfo(ctx, getFileHandler, username)
+	if err != nil {
+		return Summary{}, err
+	}
+	// entries are on dates, monday can have a time
+	from := time.Date(monday.Year(), monday.Month(), monday.Day(), 0, 0, 0, 0, time.UTC)
+	model, days, err := loadScoring(ctx, getFileHandler, userInfo, from, from.AddDate(0, 0, 6))
+	if err != nil {
+		return Summary{}, err
+	}
+	return model.Summarize(newJWTClaims(userInfo, now), weekDays(daysByDate(days), monday)), nil
+}
+
+// emitGoal sends a goal event for the week and remembers it was sent
+func (config *webhookConfig) emitGoal(event webhookEvent, username string, monday time.Time, s Summary, now time.Time) error {
+	week := monday.Format(time.DateOnly)
+	err := config.emit(webhookPayload{Event: event, User: username, Week: week, Summary: newWebhookSummary(s)}, now)
+	if err != nil {
+		return err
+	}
+	if config.GoalWeeks == nil {
+		config.GoalWeeks = make(map[string]webhookEvent)
+	}
+	config.GoalWeeks[week] = event
+	return nil
+}
+
+// emit queues a delivery to each webhook subscribed to the event, see deliverWebhooks
+func (config *webhookConfig) emit(payload webhookPayload, now time.Time) error {
+	for _, hook := range config.Hooks {
+		if !slices.Contains(hook.Events, payload.Event) {
+			continue
+		}
+		id, err := newWebhookID()
+		if err != nil {
+			return err
+		}
+		payload.ID = id
+		payload.Time = now
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("encoding webhook: %w", err)
+		}
+
+		d := webhookDelivery{ID: id, HookID: hook.ID, URL: hook.URL, Event: payload.Event, Payload: body, Created: now, NextAttempt: now}
+		config.Deliveries = slices.Insert(config.Deliveries, 0, d)
+	}
+	return nil
+}
+
+// attemptWebhook posts the delivery, scheduling the next attempt if it fails
+func attemptWebhook(ctx context.Context, hooks []webhook, d *webhookDelivery, now time.Time) {
+	d.Attempts++
+	d.LastAttempt = now
+	d.NextAttempt = time.Time{}
+
+	i := slices.IndexFunc(hooks, func(h webhook) bool { return h.ID == d.HookID })
+	if i < 0 {
+		d.StatusCode = 0
+		d.Error = "the webhook was deleted"
+		return
+	}
+
+	d.StatusCode, d.Error = postWebhook(ctx, hooks[i], *d)
+	if d.Error == "" {
+		d.Delivered = true
+		return
+	}
+	if d.Attempts <= len(webhookBackoff) {
+		d.NextAttempt = now.Add(webhookBackoff[d.Attempts-1])
+	}
+}
+
+// postWebhook returns the response status and what went wrong, if anything
+func postWebhook(ctx context.Context, hook webhook, d webhookDelivery) (int, string) {
+	ctx, cancel := context.WithTimeout(ctx, webhookClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "activity-tracker-webhooks")
+	req.Header.Set("X-Tracker-Event", string(d.Event))
+	req.Header.Set("X-Tracker-Delivery", d.ID)
+	req.Header.Set("X-Tracker-Signature", signWebhook(hook.Secret, d.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	safeClose(ctx, resp.Body, "webhook response")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, resp.Status
+	}
+	return resp.StatusCode, ""
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_signWebhook(t *testing.T) {
+	got := signWebhook("It's a Secret to Everybody", []byte("Hello, World!"))
+	want := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if got != want {
+		t.Errorf("signWebhook() = %s, want %s", got, want)
+	}
+}
+
+func Test_webhooks(t *testing.T) {
+	ctx := context.Background()
+	allowLocalWebhooks(t)
+	storage := LocalStorage{Dir: t.TempDir()}
+	receiver := newWebhookReceiver(t)
+	monday := time.Date(2024, 6, 17, 9, 0, 0, 0, time.UTC)
+
+	err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := addWebhook(ctx, storage.Open, "alice", receiver.URL, webhookEvents, monday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver.secret = hook.Secret
+
+	add := func(date time.Time, minutes int) {
+		entry := DayEntry{Duration: time.Duration(minutes) * time.Minute, Effort: 0.6}
+		err := addEntry(ctx, storage.Open, "alice", date, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// added as they happen
+		sendEntryWebhooks(ctx, storage.Open, "alice", webhookEntryCreated, date, entry, nil, date)
+		webhookSends.Wait()
+	}
+
+	// 150 minutes of moderate meets the goal, only the first time
+	add(monday, 60)
+	add(monday.AddDate(0, 0, 1), 90)
+	add(monday.AddDate(0, 0, 2), 30)
+	want := []webhookEvent{webhookEntryCreated, webhookEntryCreated, webhookGoalMet, webhookEntryCreated}
+	got := receiver.events()
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Event != want[i] {
+			t.Errorf("event %d = %s, want %s", i, got[i].Event, want[i])
+		}
+	}
+	if got[1].Entry == nil || got[1].Entry.Date != "2024-06-18" || got[1].Entry.Duration != 90*time.Minute {
+		t.Errorf("entry payload = %+v", got[1].Entry)
+	}
+	if got[2].Week != "2024-06-17" || got[2].Summary == nil || got[2].Summary.ComboScore != 100 {
+		t.Errorf("goal payload = %+v", got[2])
+	}
+
+	// the following monday's job reports the goal as met already, so there's nothing to send
+	nextMonday := monday.AddDate(0, 0, 7)
+	err = runWebhooksJob(ctx, storage, nextMonday)
+	if err != nil || len(receiver.events()) != 4 {
+		t.Fatalf("runWebhooksJob() = %v with %d events, want no new ones", err, len(receiver.events()))
+	}
+
+	// a failing receiver is retried with backoff
+	receiver.fail(1)
+	add(nextMonday, 10)
+	config, err := readWebhooks(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := config.Deliveries[0]
+	if !d.Pending() || d.Attempts != 1 || d.StatusCode != http.StatusInternalServerError || !d.NextAttempt.Equal(nextMonday.Add(webhookBackoff[0])) {
+		t.Fatalf("failed delivery = %+v", d)
+	}
+	err = runWebhooksJob(ctx, storage, nextMonday.Add(30*time.Second))
+	if err != nil || len(receiver.events()) != 4 {
+		t.Fatalf("retried %d before it was due, err %v", len(receiver.events())-4, err)
+	}
+	err = runWebhooksJob(ctx, storage, nextMonday.Add(webhookBackoff[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = readWebhooks(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := config.Deliveries[0]; !d.Delivered || d.Attempts != 2 {
+		t.Errorf("retried delivery = %+v", d)
+	}
+
+	// nothing was done the week after, which is missed once that week is over
+	err = runWebhooksJob(ctx, storage, nextMonday.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runWebhooksJob(ctx, storage, nextMonday.AddDate(0, 0, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := receiver.events()
+	if len(events) != 6 || events[5].Event != webhookGoalMissed || events[5].Week != "2024-06-24" {
+		t.Errorf("got events %v, want a single goal.missed for 2024-06-24 last", events)
+	}
+}
+
+func Test_isPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.215.14":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00:ec2::254":    false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// Test_webhooks_internal is webhooks to this host, refused when they're added, or when they're sent if the name resolves to it
+func Test_webhooks_internal(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://[::1]/hook", "http://169.254.169.254/latest/meta-data"} {
+		_, err := addWebhook(ctx, storage.Open, "alice", u, webhookEvents, time.Now())
+		if err == nil {
+			t.Errorf("addWebhook(%s) was allowed", u)
+		}
+	}
+
+	receiver := newWebhookReceiver(t)
+	status, errMsg := postWebhook(ctx, webhook{URL: receiver.URL}, webhookDelivery{Payload: []byte("{}")})
+	if status != 0 || !strings.Contains(errMsg, "can't be sent to 127.0.0.1") {
+		t.Errorf("postWebhook() = %d, %q", status, errMsg)
+	}
+	if got := receiver.events(); len(got) != 0 {
+		t.Errorf("the receiver got %v", got)
+	}
+}
+
+// Test_sendEntryWebhooks_background is a slow receiver, which the entry change doesn't wait for
+func Test_sendEntryWebhooks_background(t *testing.T) {
+	ctx := context.Background()
+	allowLocalWebhooks(t)
+	storage := LocalStorage{Dir: t.TempDir()}
+	day := time.Date(2024, 6, 17, 9, 0, 0, 0, time.UTC)
+	arrived, release := make(chan struct{}), make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	}))
+	t.Cleanup(receiver.Close)
+
+	err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = addWebhook(ctx, storage.Open, "alice", receiver.URL, []webhookEvent{webhookEntryCreated}, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendEntryWebhooks(ctx, storage.Open, "alice", webhookEntryCreated, day, DayEntry{Duration: time.Hour, Effort: 0.5}, nil, day)
+	<-arrived
+	config, err := readWebhooks(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Deliveries) != 1 || !config.Deliveries[0].Pending() {
+		t.Errorf("deliveries while sending = %+v, want one pending", config.Deliveries)
+	}
+	// sending claimed it, so the job doesn't send it too
+	err = runWebhooksJob(ctx, storage, day.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	webhookSends.Wait()
+	config, err = readWebhooks(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := config.Deliveries[0]; !d.Delivered || d.Attempts != 1 {
+		t.Errorf("delivery = %+v, want delivered on the first attempt", d)
+	}
+}
+
+// Test_webhooksJob_newWebhook is a webhook added on a monday, which shouldn't hear about the week before
+func Test_webhooksJob_newWebhook(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	allowLocalWebhooks(t)
+	receiver := newWebhookReceiver(t)
+	monday := time.Date(2024, 6, 24, 9, 0, 0, 0, time.UTC)
+
+	err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := addWebhook(ctx, storage.Open, "alice", receiver.URL, webhookEvents, monday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver.secret = hook.Secret
+
+	err = runWebhooksJob(ctx, storage, monday.Add(time.Hour))
+	if err != nil || len(receiver.events()) != 0 {
+		t.Fatalf("runWebhooksJob() = %v with events %v, want none", err, receiver.events())
+	}
+	// this week is missed once it's over
+	err = runWebhooksJob(ctx, storage, monday.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := receiver.events(); len(events) != 1 || events[0].Event != webhookGoalMissed || events[0].Week != "2024-06-24" {
+		t.Errorf("got events %v, want goal.missed for 2024-06-24", events)
+	}
+}
+
+// Test_addWebhook_concurrent is what lost webhooks, each write replacing the others
+func Test_addWebhook_concurrent(t *testing.T) {
+	ctx := context.Background()
+	storage := LocalStorage{Dir: t.TempDir()}
+	err := createUser(ctx, storage.Open, UserInfo{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := addWebhook(ctx, storage.Open, "alice", fmt.Sprintf("https://example.com/hook/%d", i), webhookEvents, time.Now())
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	config, err := readWebhooks(ctx, storage.Open, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Hooks) != 10 {
+		t.Errorf("got %d webhooks, want all 10", len(config.Hooks))
+	}
+}
+
+// webhookReceiver checks the signature on each request, and can fail some first
+type webhookReceiver struct {
+	*httptest.Server
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	received []webhookPayload
+	failures int
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	r := &webhookReceiver{t: t}
+	r.Server = httptest.NewServer(r)
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	if got := req.Header.Get("X-Tracker-Signature"); got != signWebhook(r.secret, body) {
+		r.t.Errorf("bad signature %s for %s", got, body)
+	}
+	var p webhookPayload
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		r.t.Errorf("bad payload %s: %v", body, err)
+	}
+	if req.Header.Get("X-Tracker-Event") != string(p.Event) {
+		r.t.Errorf("event header %s for %s", req.Header.Get("X-Tracker-Event"), p.Event)
+	}
+	r.received = append(r.received, p)
+}
+
+// allowLocalWebhooks lets the test send to its receivers, which are on this host
+func allowLocalWebhooks(t *testing.T) {
+	webhookAddrAllowed = func(netip.Addr) bool { return true }
+	t.Cleanup(func() { webhookAddrAllowed = isPublicAddr })
+}
+
+func (r *webhookReceiver) fail(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = n
+}
+
+func (r *webhookReceiver) events() []webhookPayload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhookPayload(nil), r.received...)
+}